@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
 	"aoc/days"
+	"aoc/opcode"
 	"aoc/utils"
 )
 
@@ -14,11 +16,26 @@ var (
 	part      int
 	input     []string
 	inputPath string
+	trace     bool
+	// profilePath is where to write the Intcode profile, if one is wanted
+	profilePath string
+	profiler    *opcode.Profiler
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "aoc",
 	Short: "Run the Advent of Code 2019 Program",
+	// Errors from solving a puzzle aren't usage errors, so don't print the usage for them
+	SilenceUsage: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if trace {
+			opcode.AddDefaultHooks(opcode.NewTracer(os.Stderr))
+		}
+		if profilePath != "" {
+			profiler = opcode.NewProfiler()
+			opcode.AddDefaultHooks(profiler.Hooks())
+		}
+	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		// Validate the arguments
 
@@ -46,11 +63,42 @@ func init() {
 	rootCmd.PersistentFlags().IntVarP(&day, "day", "d", 0, "Day to run")
 	rootCmd.PersistentFlags().IntVarP(&part, "part", "p", 0, "Part to run")
 	rootCmd.PersistentFlags().StringVarP(&inputPath, "input", "i", "", "Path to the input file")
+	rootCmd.PersistentFlags().BoolVar(&trace, "trace", false, "Write a line to stderr for every Intcode instruction executed")
+	rootCmd.PersistentFlags().StringVar(&profilePath, "intcode-profile", "", "Profile the Intcode instructions executed, writing a summary to stderr and the full profile to this path as JSON")
 }
 
 // Execute executes the root Cobra command
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		panic(err)
+	err := rootCmd.Execute()
+	// The profile is written even if the command failed, since that is when it is most useful
+	if profileErr := writeProfile(); profileErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", profileErr)
+		os.Exit(1)
+	}
+	if err != nil {
+		// Cobra has already printed the error
+		os.Exit(1)
+	}
+}
+
+// writeProfile writes a summary of the Intcode profile to stderr and the full profile to the profile path, if the
+// instructions were being profiled
+func writeProfile() error {
+	if profiler == nil {
+		return nil
+	}
+
+	report := profiler.Report()
+	if err := report.WriteTable(os.Stderr); err != nil {
+		return err
+	}
+	f, err := os.Create(profilePath)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
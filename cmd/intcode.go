@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"aoc/opcode"
+	"aoc/utils"
+)
+
+var (
+	profile string
+	// coverageInputs are the inputs given to the program by the coverage command
+	coverageInputs []int
+)
+
+var intcodeCmd = &cobra.Command{
+	Use:   "intcode",
+	Short: "Tools for working with Intcode programs",
+}
+
+var disasmCmd = &cobra.Command{
+	Use:   "disasm <program>",
+	Short: "Print an annotated listing of an Intcode program",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		codes, err := loadProgram(args[0])
+		if err != nil {
+			return err
+		}
+
+		for _, line := range opcode.Disassemble(codes) {
+			fmt.Println(line)
+		}
+		return nil
+	},
+}
+
+var asmCmd = &cobra.Command{
+	Use:   "asm <source>",
+	Short: "Assemble Intcode assembly into a comma-separated program",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		src, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		codes, err := opcode.Assemble(string(src))
+		if err != nil {
+			return err
+		}
+
+		values := make([]string, len(codes))
+		for i, code := range codes {
+			values[i] = strconv.Itoa(code)
+		}
+		fmt.Println(strings.Join(values, ","))
+		return nil
+	},
+}
+
+var debugCmd = &cobra.Command{
+	Use:   "debug <program>",
+	Short: "Step through an Intcode program interactively",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		codes, err := loadProgram(args[0])
+		if err != nil {
+			return err
+		}
+
+		d := opcode.NewDebugger(codes)
+		if profile != "" {
+			s, err := opcode.Profile(profile)
+			if err != nil {
+				return err
+			}
+			d.Machine().SetInstructionSet(s)
+		}
+
+		fmt.Println("Type \"help\" for a list of commands")
+		return d.Run(os.Stdin, os.Stdout)
+	},
+}
+
+var asciiCmd = &cobra.Command{
+	Use:   "ascii <program>",
+	Short: "Run an Intcode program that talks in ASCII, sending it lines typed at the terminal",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		codes, err := loadProgram(args[0])
+		if err != nil {
+			return err
+		}
+		return opcode.NewASCIIMachine(codes).Interact(os.Stdin, os.Stdout)
+	},
+}
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage <program>",
+	Short: "Run an Intcode program and print a listing showing which instructions were executed and which data was used",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		codes, err := loadProgram(args[0])
+		if err != nil {
+			return err
+		}
+
+		coverage := opcode.NewCoverage()
+		out := &opcode.SliceOutput{}
+		m := opcode.NewMachine(append(codes[:0:0], codes...), opcode.NewSliceInput(coverageInputs...), out)
+		m.AddHooks(coverage.Hooks())
+		status, runErr := m.Run()
+
+		if err := coverage.WriteReport(os.Stdout, codes); err != nil {
+			return err
+		}
+		fmt.Printf("Outputs: %v\n", out.Values)
+		if runErr == nil && status == opcode.StatusNeedsInput {
+			fmt.Println("The program stopped waiting for more input than was given")
+		}
+		return runErr
+	},
+}
+
+// loadProgram loads a comma-separated Intcode program from the given path
+func loadProgram(path string) ([]int, error) {
+	lines, err := utils.LoadInputFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return opcode.Parse(strings.Join(lines, ""))
+}
+
+func init() {
+	rootCmd.AddCommand(intcodeCmd)
+	intcodeCmd.AddCommand(disasmCmd)
+	intcodeCmd.AddCommand(asmCmd)
+	intcodeCmd.AddCommand(debugCmd)
+	intcodeCmd.AddCommand(asciiCmd)
+	intcodeCmd.AddCommand(coverageCmd)
+
+	debugCmd.Flags().StringVar(&profile, "profile", "", "Only allow the instructions of an earlier puzzle: "+strings.Join(opcode.ProfileNames(), ", "))
+	coverageCmd.Flags().IntSliceVar(&coverageInputs, "inputs", nil, "Comma-separated inputs to give the program")
+}
@@ -7,13 +7,12 @@ import (
 	"math"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 // Day11Part1 solves Day 11, Part 1
 func Day11Part1(input []string) (string, error) {
 	codeStrings := strings.Split(input[0], ",")
-	codes := make([]int, len(codeStrings)*1000)
+	codes := make([]int, len(codeStrings))
 	for i, c := range codeStrings {
 		x, err := strconv.Atoi(c)
 		if err != nil {
@@ -22,75 +21,91 @@ func Day11Part1(input []string) (string, error) {
 		codes[i] = x
 	}
 
-	// Make the chans
-	in, out := make(chan int, 10), make(chan int, 10)
+	coveredPoints, err := paintHull(codes, 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", len(coveredPoints)), nil
+}
+
+// paintHull runs the painting robot program, starting on a panel of the given colour, and returns the colour of every
+// panel that was painted
+func paintHull(codes []int, startingCol int) (map[utils.Point]int, error) {
+	m := opcode.NewSyncMachine(codes)
 
-	// Process the outputs in a subroutine
 	coveredPoints := map[utils.Point]int{}
-	go func(in, out chan int, coveredPoints map[utils.Point]int) {
-		startingPoint := utils.NewPoint(0, 0)
-		direction := utils.NewVector(0, 1)
-
-		for {
-			// Send the color that we are already over
-			col, exists := coveredPoints[startingPoint]
-			if exists {
-				in <- col
+	startingPoint := utils.NewPoint(0, 0)
+	direction := utils.NewVector(0, 1)
+
+	defaultCol := startingCol
+	for {
+		// Send the color that we are already over
+		col, exists := coveredPoints[startingPoint]
+		if exists {
+			m.PushInput(col)
+		} else {
+			m.PushInput(defaultCol)
+		}
+		defaultCol = 0
+
+		// Run until the robot wants to know the next colour
+		status, err := m.RunUntilInput()
+		if err != nil {
+			return nil, err
+		}
+
+		outputs := m.Outputs()
+		if len(outputs) == 0 && status == opcode.StatusHalted {
+			// The program has terminated
+			return coveredPoints, nil
+		}
+		if len(outputs) != 2 {
+			return nil, fmt.Errorf("Expected a colour and a direction, got %+v", outputs)
+		}
+
+		// Save the new color
+		newCol, dir := outputs[0], outputs[1]
+		coveredPoints[startingPoint] = newCol
+
+		// Move
+		switch direction.Angle() {
+		case 0.0:
+			if dir == 0 {
+				direction = utils.NewVector(-1, 0)
 			} else {
-				in <- 0
+				direction = utils.NewVector(1, 0)
 			}
-			// Read the new color and save it
-			newCol := <-out
-			coveredPoints[startingPoint] = newCol
-
-			// Read the new direction
-			dir, open := <-out
-			if !open {
-				// The program has terminated
-				return
+		case 90.0:
+			if dir == 0 {
+				direction = utils.NewVector(0, 1)
+			} else {
+				direction = utils.NewVector(0, -1)
 			}
-
-			// Move
-			switch direction.Angle() {
-			case 0.0:
-				if dir == 0 {
-					direction = utils.NewVector(-1, 0)
-				} else {
-					direction = utils.NewVector(1, 0)
-				}
-			case 90.0:
-				if dir == 0 {
-					direction = utils.NewVector(0, 1)
-				} else {
-					direction = utils.NewVector(0, -1)
-				}
-			case 180.0:
-				if dir == 0 {
-					direction = utils.NewVector(1, 0)
-				} else {
-					direction = utils.NewVector(-1, 0)
-				}
-			case 270.0:
-				if dir == 0 {
-					direction = utils.NewVector(0, -1)
-				} else {
-					direction = utils.NewVector(0, 1)
-				}
+		case 180.0:
+			if dir == 0 {
+				direction = utils.NewVector(1, 0)
+			} else {
+				direction = utils.NewVector(-1, 0)
+			}
+		case 270.0:
+			if dir == 0 {
+				direction = utils.NewVector(0, -1)
+			} else {
+				direction = utils.NewVector(0, 1)
 			}
-			startingPoint = startingPoint.PlusVector(direction)
 		}
-	}(in, out, coveredPoints)
+		startingPoint = startingPoint.PlusVector(direction)
 
-	// Start the program
-	opcode.Run(codes, in, out)
-
-	return fmt.Sprintf("%d", len(coveredPoints)), nil
+		if status == opcode.StatusHalted {
+			return coveredPoints, nil
+		}
+	}
 }
 
 // Day11Part2 solves Day 11, Part 2
 func Day11Part2(input []string) (string, error) {
 	codeStrings := strings.Split(input[0], ",")
-	codes := make([]int, len(codeStrings)*1000)
+	codes := make([]int, len(codeStrings))
 	for i, c := range codeStrings {
 		x, err := strconv.Atoi(c)
 		if err != nil {
@@ -99,76 +114,13 @@ func Day11Part2(input []string) (string, error) {
 		codes[i] = x
 	}
 
-	// Make the chans
-	in, out := make(chan int, 10), make(chan int, 10)
-
-	// Process the outputs in a subroutine
-	coveredPoints := map[utils.Point]int{}
-	locker := sync.Mutex{}
-	go func(in, out chan int) {
-		startingPoint := utils.NewPoint(0, 0)
-		direction := utils.NewVector(0, 1)
-
-		defaultCol := 1
-		for {
-			// Send the color that we are already over
-			col, exists := coveredPoints[startingPoint]
-			if exists {
-				in <- col
-			} else {
-				in <- defaultCol
-				defaultCol = 0
-			}
-			// Read the new color and save it
-			newCol := <-out
-			locker.Lock()
-			coveredPoints[startingPoint] = newCol
-			locker.Unlock()
-
-			// Read the new direction
-			dir, open := <-out
-			if !open {
-				// The program has terminated
-				return
-			}
-
-			// Move
-			switch direction.Angle() {
-			case 0.0:
-				if dir == 0 {
-					direction = utils.NewVector(-1, 0)
-				} else {
-					direction = utils.NewVector(1, 0)
-				}
-			case 90.0:
-				if dir == 0 {
-					direction = utils.NewVector(0, 1)
-				} else {
-					direction = utils.NewVector(0, -1)
-				}
-			case 180.0:
-				if dir == 0 {
-					direction = utils.NewVector(1, 0)
-				} else {
-					direction = utils.NewVector(-1, 0)
-				}
-			case 270.0:
-				if dir == 0 {
-					direction = utils.NewVector(0, -1)
-				} else {
-					direction = utils.NewVector(0, 1)
-				}
-			}
-			startingPoint = startingPoint.PlusVector(direction)
-		}
-	}(in, out)
-
-	// Start the program
-	opcode.Run(codes, in, out)
+	coveredPoints, err := paintHull(codes, 1)
+	if err != nil {
+		return "", err
+	}
 
 	// Now we have all the points that have been written, so just draw it
 	minX, maxX, minY, maxY := 0, 0, 0, 0
-	locker.Lock()
 	for point := range coveredPoints {
 		if point.X < minX {
 			minX = point.X
@@ -186,7 +138,6 @@ func Day11Part2(input []string) (string, error) {
 			maxY = point.Y
 		}
 	}
-	locker.Unlock()
 
 	width := int(math.Abs(float64(minX))+math.Abs(float64(maxX))) + 1
 	height := int(math.Abs(float64(minY))+math.Abs(float64(maxY))) + 1
@@ -195,13 +146,11 @@ func Day11Part2(input []string) (string, error) {
 		grid[i] = make([]int, width)
 	}
 
-	locker.Lock()
 	for point, col := range coveredPoints {
 		// Need to shift the coordinates here to make them inside the range
 		absX, absY := int(math.Abs(float64(point.X))), int(math.Abs(float64(point.Y)))
 		grid[absY][absX] = col
 	}
-	locker.Unlock()
 
 	hull := ""
 	for _, row := range grid {
@@ -3,66 +3,51 @@ package days
 import (
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+
+	"aoc/opcode"
 )
 
 // Day2Part1 solves Day 2, Part 1
 func Day2Part1(input []string) (string, error) {
-	codeStrings := strings.Split(input[0], ",")
-	codes := make([]int, len(codeStrings))
-	for i, c := range codeStrings {
-		x, err := strconv.Atoi(c)
-		if err != nil {
-			return "", err
-		}
-		codes[i] = x
+	codes, err := opcode.Parse(input[0])
+	if err != nil {
+		return "", err
+	}
+	profile, err := opcode.Profile("day2")
+	if err != nil {
+		return "", err
 	}
 
 	// Set the initial values
 	codes[1] = 12
 	codes[2] = 2
 
-	res, err := process(codes)
+	res, err := process(codes, profile)
 	if err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("%d", res), nil
 }
 
-func process(codes []int) (int, error) {
-	i := 0
-
-	for {
-		op := codes[i]
-		i1 := codes[i+1]
-		i2 := codes[i+2]
-		dest := codes[i+3]
-		switch op {
-		case 1:
-			codes[dest] = codes[i1] + codes[i2]
-		case 2:
-			codes[dest] = codes[i1] * codes[i2]
-		case 99:
-			return codes[0], nil
-		default:
-			return 0, fmt.Errorf("Invalid opcode %d", op)
-		}
-
-		i += 4
+// process runs a program until it halts, returning the value left at address 0
+func process(codes []int, profile *opcode.InstructionSet) (int, error) {
+	m := opcode.NewMachine(codes, nil, nil)
+	m.SetInstructionSet(profile)
+	if _, err := m.Run(); err != nil {
+		return 0, err
 	}
+	return m.Memory().Read(0)
 }
 
 // Day2Part2 solves Day 2, Part 2
 func Day2Part2(input []string) (string, error) {
-	codeStrings := strings.Split(input[0], ",")
-	codes := make([]int, len(codeStrings))
-	for i, c := range codeStrings {
-		x, err := strconv.Atoi(c)
-		if err != nil {
-			return "", err
-		}
-		codes[i] = x
+	codes, err := opcode.Parse(input[0])
+	if err != nil {
+		return "", err
+	}
+	profile, err := opcode.Profile("day2")
+	if err != nil {
+		return "", err
 	}
 
 	for noun := 0; noun < 100; noun++ {
@@ -74,7 +59,7 @@ func Day2Part2(input []string) (string, error) {
 			currCodes[1] = noun
 			currCodes[2] = verb
 
-			res, err := process(currCodes)
+			res, err := process(currCodes, profile)
 			if err != nil {
 				return "", err
 			}
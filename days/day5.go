@@ -1,6 +1,7 @@
 package days
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -20,31 +21,24 @@ func Day5Part1(input []string) (string, error) {
 		codes[i] = x
 	}
 
-	in := make(chan int)
-	out := make(chan int)
-
-	var err error
-	go func() {
-		err = opcode.Run(codes, in, out)
-	}()
-
-	go func() {
-		in <- 1
-	}()
-
-	output, open := <-out
-	var prevOut int
-	for open {
-		prevOut = output
-		output, open = <-out
+	profile, err := opcode.Profile("day5")
+	if err != nil {
+		return "", err
 	}
 
-	// Now check the error
-	if err != nil {
+	out := &opcode.SliceOutput{}
+	m := opcode.NewMachine(codes, opcode.NewSliceInput(1), out)
+	m.SetInstructionSet(profile)
+	if _, err := m.Run(); err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%d", prevOut), nil
+	// The diagnostic code is the final output
+	res, ok := out.Last()
+	if !ok {
+		return "", errors.New("No output produced")
+	}
+	return fmt.Sprintf("%d", res), nil
 }
 
 // Day5Part2 solves Day 5, Part 2
@@ -59,29 +53,22 @@ func Day5Part2(input []string) (string, error) {
 		codes[i] = x
 	}
 
-	in := make(chan int)
-	out := make(chan int)
-
-	var err error
-	go func() {
-		err = opcode.Run(codes, in, out)
-	}()
-
-	go func() {
-		in <- 5
-	}()
-
-	output, open := <-out
-	var prevOut int
-	for open {
-		prevOut = output
-		output, open = <-out
+	profile, err := opcode.Profile("day5")
+	if err != nil {
+		return "", err
 	}
 
-	// Now check the error
-	if err != nil {
+	out := &opcode.SliceOutput{}
+	m := opcode.NewMachine(codes, opcode.NewSliceInput(5), out)
+	m.SetInstructionSet(profile)
+	if _, err := m.Run(); err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%d", prevOut), nil
+	// The diagnostic code is the final output
+	res, ok := out.Last()
+	if !ok {
+		return "", errors.New("No output produced")
+	}
+	return fmt.Sprintf("%d", res), nil
 }
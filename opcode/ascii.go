@@ -0,0 +1,119 @@
+package opcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ASCIIResult is something produced by a program that talks in ASCII: either a line of text, or a single value outside
+// the ASCII range
+type ASCIIResult struct {
+	// Line is a line of text, without its newline
+	Line string
+	// Value is the value produced, if IsValue is true
+	Value   int
+	IsValue bool
+}
+
+// String returns the line, or the value as a number
+func (r ASCIIResult) String() string {
+	if r.IsValue {
+		return fmt.Sprintf("%d", r.Value)
+	}
+	return r.Line
+}
+
+// ASCIIMachine runs a program that talks in ASCII, taking its input as lines of text and collecting its output into
+// lines. Values outside the ASCII range are passed through as they are, which is how programs usually give their
+// final answer
+type ASCIIMachine struct {
+	m *Machine
+	// partial is the text of the line being output
+	partial strings.Builder
+}
+
+// NewASCIIMachine creates a new ASCIIMachine running the given program
+func NewASCIIMachine(codes []int) *ASCIIMachine {
+	return &ASCIIMachine{
+		m: NewSyncMachine(codes),
+	}
+}
+
+// Machine returns the machine running the program
+func (a *ASCIIMachine) Machine() *Machine {
+	return a.m
+}
+
+// SendLine gives lines of text to the program, each followed by a newline. It fails without sending anything if any
+// of the lines contain characters outside the ASCII range
+func (a *ASCIIMachine) SendLine(lines ...string) error {
+	values := []int{}
+	for _, line := range lines {
+		for _, r := range line {
+			if r > 127 {
+				return fmt.Errorf("Line %q contains the non-ASCII character %q", line, r)
+			}
+			values = append(values, int(r))
+		}
+		values = append(values, '\n')
+	}
+	a.m.PushInput(values...)
+	return nil
+}
+
+// Run runs the program until it halts or needs input that hasn't been sent yet, and returns what it output. A line
+// is ended early by a value outside the ASCII range, or by the program stopping, so a prompt without a newline is
+// still returned
+func (a *ASCIIMachine) Run() ([]ASCIIResult, Status, error) {
+	status, err := a.m.RunUntilInput()
+
+	results := []ASCIIResult{}
+	for _, val := range a.m.Outputs() {
+		switch {
+		case val == '\n':
+			results = append(results, ASCIIResult{Line: a.partial.String()})
+			a.partial.Reset()
+		case val >= 0 && val <= 127:
+			a.partial.WriteByte(byte(val))
+		default:
+			results = a.flush(results)
+			results = append(results, ASCIIResult{Value: val, IsValue: true})
+		}
+	}
+	return a.flush(results), status, err
+}
+
+// Interact runs the program as an interactive session, writing its output to `w` and sending it lines read from `r`
+// whenever it needs input. Lines that can't be sent are reported to `w` and skipped. It returns once the program halts,
+// or there are no more lines to read
+func (a *ASCIIMachine) Interact(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		results, status, err := a.Run()
+		for _, result := range results {
+			fmt.Fprintln(w, result)
+		}
+		if err != nil || status == StatusHalted {
+			return err
+		}
+
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		if err := a.SendLine(scanner.Text()); err != nil {
+			fmt.Fprintf(w, "Error: %v\n", err)
+		}
+	}
+}
+
+// flush adds any partial line to the results
+func (a *ASCIIMachine) flush(results []ASCIIResult) []ASCIIResult {
+	if a.partial.Len() == 0 {
+		return results
+	}
+	results = append(results, ASCIIResult{Line: a.partial.String()})
+	a.partial.Reset()
+	return results
+}
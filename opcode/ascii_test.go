@@ -0,0 +1,59 @@
+package opcode_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+// echo prompts with "> ", repeats back a line of input, then outputs 1000 and halts
+var echo = opcode.MustAssemble(`
+	       out #62
+	       out #32
+	loop:  in [c]
+	       eq [c], #10, [t]
+	       jt [t], #end
+	       out [c]
+	       jt #1, #loop
+	end:   out #10
+	       out #1000
+	       hlt
+	c:     data 0
+	t:     data 0
+`)
+
+func TestASCIIMachine(t *testing.T) {
+	a := opcode.NewASCIIMachine(append(echo[:0:0], echo...))
+
+	results, status, err := a.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Equal(t, []opcode.ASCIIResult{{Line: "> "}}, results)
+
+	require.NoError(t, a.SendLine("hello"))
+	results, status, err = a.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+	require.Equal(t, []opcode.ASCIIResult{{Line: "hello"}, {Value: 1000, IsValue: true}}, results)
+}
+
+func TestASCIIMachineSendLine(t *testing.T) {
+	a := opcode.NewASCIIMachine(append(echo[:0:0], echo...))
+	require.EqualError(t, a.SendLine("ok", "café"), "Line \"café\" contains the non-ASCII character 'é'")
+
+	// Nothing was sent, so the program is still waiting
+	_, status, err := a.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+}
+
+func TestASCIIMachineInteract(t *testing.T) {
+	a := opcode.NewASCIIMachine(append(echo[:0:0], echo...))
+	out := &bytes.Buffer{}
+	require.NoError(t, a.Interact(strings.NewReader("héllo\nhello\nignored\n"), out))
+	require.Equal(t, "> \nError: Line \"héllo\" contains the non-ASCII character 'é'\nhello\n1000\n", out.String())
+}
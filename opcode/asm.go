@@ -0,0 +1,240 @@
+package opcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Assemble converts Intcode assembly into a program. Each line holds an optional label, followed by an optional
+// instruction or directive, followed by an optional comment:
+//
+//	loop:   add [counter], #-1, [counter]   ; count down
+//	        jt [counter], #loop
+//	        hlt
+//	counter: data 10
+//
+// Instructions use the mnemonics from the disassembler, and operands are written with the sigil for their mode:
+// `[addr]` for position mode, `#value` for immediate mode and `rb+offset` for relative mode. The `data` directive
+// emits its comma-separated values as they are. Anywhere a number is expected, a label (optionally plus or minus a
+// number) can be used instead, and it is replaced with the address of the label
+func Assemble(src string) ([]int, error) {
+	return DefaultInstructions.Assemble(src)
+}
+
+// Assemble converts Intcode assembly into a program in the same way as the Assemble function, but with the mnemonics
+// of the instructions in the set
+func (s *InstructionSet) Assemble(src string) ([]int, error) {
+	statements := []asmStatement{}
+	labels := map[string]int{}
+
+	// First work out where everything goes, so labels can be used before they are defined
+	addr := 0
+	for i, text := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		if idx := strings.Index(text, ";"); idx >= 0 {
+			text = text[:idx]
+		}
+		text = strings.TrimSpace(text)
+
+		if idx := strings.Index(text, ":"); idx >= 0 {
+			label := strings.TrimSpace(text[:idx])
+			if !isLabel(label) {
+				return nil, fmt.Errorf("Line %d: invalid label %q", lineNo, label)
+			}
+			if _, exists := labels[label]; exists {
+				return nil, fmt.Errorf("Line %d: label %q defined more than once", lineNo, label)
+			}
+			labels[label] = addr
+			text = strings.TrimSpace(text[idx+1:])
+		}
+		if text == "" {
+			continue
+		}
+
+		statement, err := s.parseStatement(text)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", lineNo, err)
+		}
+		statement.line = lineNo
+		statements = append(statements, statement)
+		addr += statement.size()
+	}
+
+	// Then emit the values, now that every label is known
+	codes := make([]int, 0, addr)
+	for _, statement := range statements {
+		values, err := statement.emit(labels)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", statement.line, err)
+		}
+		codes = append(codes, values...)
+	}
+	return codes, nil
+}
+
+// MustAssemble is like Assemble but panics if the assembly is invalid. It is intended for programs written in code,
+// such as in tests
+func MustAssemble(src string) []int {
+	codes, err := Assemble(src)
+	if err != nil {
+		panic(err)
+	}
+	return codes
+}
+
+// asmStatement is a single instruction or directive
+type asmStatement struct {
+	line        int
+	data        bool
+	instruction Instruction
+	modes       []Mode
+	// args are the unresolved values of the operands, or of the data
+	args []string
+}
+
+// size returns the number of values the statement emits
+func (s asmStatement) size() int {
+	if s.data {
+		return len(s.args)
+	}
+	return len(s.args) + 1
+}
+
+// emit returns the values for the statement
+func (s asmStatement) emit(labels map[string]int) ([]int, error) {
+	values := []int{}
+	if !s.data {
+		code := int(s.instruction)
+		for i, mode := range s.modes {
+			code += int(mode) * pow10(i+2)
+		}
+		values = append(values, code)
+	}
+
+	for _, arg := range s.args {
+		val, err := evaluate(arg, labels)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// parseStatement parses an instruction or directive, without resolving any labels
+func (s *InstructionSet) parseStatement(text string) (asmStatement, error) {
+	mnemonic, rest := text, ""
+	if idx := strings.IndexFunc(text, unicode.IsSpace); idx >= 0 {
+		mnemonic, rest = text[:idx], strings.TrimSpace(text[idx:])
+	}
+	mnemonic = strings.ToLower(mnemonic)
+
+	args := []string{}
+	if rest != "" {
+		for _, arg := range strings.Split(rest, ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	}
+
+	if mnemonic == "data" {
+		if len(args) == 0 {
+			return asmStatement{}, fmt.Errorf("data needs at least one value")
+		}
+		return asmStatement{
+			data: true,
+			args: args,
+		}, nil
+	}
+
+	def, ok := s.LookupMnemonic(mnemonic)
+	if !ok {
+		return asmStatement{}, fmt.Errorf("unknown mnemonic %q", mnemonic)
+	}
+	if len(args) != def.Arity() {
+		return asmStatement{}, fmt.Errorf("%s takes %d operands, got %d", mnemonic, def.Arity(), len(args))
+	}
+
+	statement := asmStatement{
+		instruction: def.Instruction,
+		modes:       make([]Mode, len(args)),
+		args:        make([]string, len(args)),
+	}
+	for i, arg := range args {
+		mode, value, err := parseOperand(arg)
+		if err != nil {
+			return asmStatement{}, err
+		}
+		if def.writes(i) && mode == ModeImmediate {
+			return asmStatement{}, fmt.Errorf("operand %d of %s is written to, so can't be immediate", i+1, mnemonic)
+		}
+		statement.modes[i], statement.args[i] = mode, value
+	}
+	return statement, nil
+}
+
+// parseOperand splits an operand into its mode and its unresolved value
+func parseOperand(arg string) (Mode, string, error) {
+	switch {
+	case strings.HasPrefix(arg, "[") && strings.HasSuffix(arg, "]"):
+		return ModePosition, strings.TrimSpace(arg[1 : len(arg)-1]), nil
+	case strings.HasPrefix(arg, "#"):
+		return ModeImmediate, strings.TrimSpace(arg[1:]), nil
+	case arg == "rb":
+		return ModeRelative, "0", nil
+	case strings.HasPrefix(arg, "rb+") || strings.HasPrefix(arg, "rb-"):
+		// Keep the sign so that the offset can be evaluated like any other value
+		return ModeRelative, strings.TrimSpace(arg[2:]), nil
+	default:
+		return 0, "", fmt.Errorf("operand %q has no mode, use [x], #x or rb+x", arg)
+	}
+}
+
+// evaluate resolves a number, a label, or a label plus or minus a number
+func evaluate(expr string, labels map[string]int) (int, error) {
+	expr = strings.Replace(expr, " ", "", -1)
+	if expr == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+	if val, err := strconv.Atoi(expr); err == nil {
+		return val, nil
+	}
+
+	// Find where the label ends and the offset starts, skipping a leading sign
+	label, offset := expr, 0
+	if idx := strings.IndexAny(expr[1:], "+-"); idx >= 0 {
+		label = expr[:idx+1]
+		var err error
+		offset, err = strconv.Atoi(expr[idx+1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset in %q", expr)
+		}
+	}
+
+	sign := 1
+	if strings.HasPrefix(label, "+") {
+		label = label[1:]
+	} else if strings.HasPrefix(label, "-") {
+		sign, label = -1, label[1:]
+	}
+
+	addr, ok := labels[label]
+	if !ok {
+		return 0, fmt.Errorf("undefined label %q", label)
+	}
+	return sign*addr + offset, nil
+}
+
+// isLabel returns true iff the string is a valid label name
+func isLabel(s string) bool {
+	if s == "" || s == "rb" || s == "data" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package opcode_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+	"aoc/utils"
+)
+
+func TestAssemble(t *testing.T) {
+	cases := map[string]struct {
+		src      string
+		expected []int
+	}{
+		"Modes": {
+			src:      "mul [4], #3, [4]\ndata 33",
+			expected: []int{1002, 4, 3, 4, 33},
+		},
+		"Relative Mode": {
+			src:      "arb #1\nout rb-1\nadd rb, rb+2, rb+3\nhlt",
+			expected: []int{109, 1, 204, -1, 22201, 0, 2, 3, 99},
+		},
+		"Labels": {
+			src: `
+				; Counts down from 3, outputting each value
+				loop:    out [counter]
+				         add [counter], #-1, [counter]
+				         jt [counter], #loop
+				         hlt
+				counter: data 3
+			`,
+			expected: []int{4, 10, 1001, 10, -1, 10, 1005, 10, 0, 99, 3},
+		},
+		"Label Offsets": {
+			src:      "start: jt #1, #end-1\nend: data start+7, -end",
+			expected: []int{1105, 1, 2, 7, -3},
+		},
+		"Label On Its Own Line": {
+			src:      "out #1\nhere:\nout #here\nhlt",
+			expected: []int{104, 1, 104, 2, 99},
+		},
+	}
+
+	for name, data := range cases {
+		codes, err := opcode.Assemble(data.src)
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, data.expected, codes, "Case %s", name)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := map[string]string{
+		"Unknown Mnemonic":      "jmp #0",
+		"Wrong Operand Count":   "add [1], [2]",
+		"No Mode":               "out 5",
+		"Immediate Destination": "add #1, #2, #3",
+		"Undefined Label":       "jt #1, #nowhere",
+		"Duplicate Label":       "a: hlt\na: hlt",
+		"Invalid Label":         "1a: hlt",
+		"Empty Data":            "data",
+	}
+
+	for name, src := range cases {
+		_, err := opcode.Assemble(src)
+		require.Errorf(t, err, "Case %s", name)
+	}
+}
+
+func TestAssembleDisassembleRoundTrip(t *testing.T) {
+	for _, day := range []int{5, 9, 11} {
+		input, err := utils.LoadInputFromPath(fmt.Sprintf("../inputs/day%d.input", day))
+		if err != nil {
+			t.Skipf("No input for Day %d", day)
+		}
+		codes, err := opcode.Parse(input[0])
+		require.NoError(t, err)
+
+		asm := []string{}
+		for _, line := range opcode.Disassemble(codes) {
+			asm = append(asm, line.Asm())
+		}
+
+		reassembled, err := opcode.Assemble(strings.Join(asm, "\n"))
+		require.NoErrorf(t, err, "Day %d", day)
+		require.Equalf(t, codes, reassembled, "Day %d", day)
+	}
+}
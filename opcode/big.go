@@ -0,0 +1,317 @@
+package opcode
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// bigZero is the value of memory that has never been written. Values in a BigMachine's memory are never modified in
+// place, so it can be shared
+var bigZero = big.NewInt(0)
+
+// BigMachine is an Intcode computer whose memory holds arbitrary-precision integers, so adding and multiplying never
+// overflow. It is much slower than Machine, and is intended for programs that legitimately produce numbers too large
+// for an int. Like a machine created by NewSyncMachine, it buffers its inputs and outputs, and Run returns after every
+// output. It only understands the standard instructions in DefaultInstructions, since custom instructions work on ints
+type BigMachine struct {
+	memory []*big.Int
+	sparse map[int]*big.Int
+
+	ip           int
+	relativeBase int
+
+	halted bool
+	err    error
+
+	inputs  []*big.Int
+	outputs []*big.Int
+}
+
+// NewBigMachine creates a new BigMachine that runs the given program
+func NewBigMachine(codes []int) *BigMachine {
+	memory := make([]*big.Int, len(codes))
+	for i, code := range codes {
+		memory[i] = big.NewInt(int64(code))
+	}
+	return &BigMachine{
+		memory: memory,
+		sparse: map[int]*big.Int{},
+	}
+}
+
+// PushInput adds values to the end of the input buffer
+func (m *BigMachine) PushInput(values ...*big.Int) {
+	m.inputs = append(m.inputs, values...)
+}
+
+// Outputs removes and returns all the values in the output buffer
+func (m *BigMachine) Outputs() []*big.Int {
+	outputs := m.outputs
+	m.outputs = nil
+	return outputs
+}
+
+// Read returns the value stored at the given address
+func (m *BigMachine) Read(addr int) (*big.Int, error) {
+	if addr < 0 {
+		return nil, fmt.Errorf("%w %d", ErrNegativeAddress, addr)
+	}
+	if addr < len(m.memory) {
+		return m.memory[addr], nil
+	}
+	if val, ok := m.sparse[addr]; ok {
+		return val, nil
+	}
+	return bigZero, nil
+}
+
+// Write stores a value at the given address, growing the memory if needed. The machine takes ownership of `val`
+func (m *BigMachine) Write(addr int, val *big.Int) error {
+	if addr < 0 {
+		return fmt.Errorf("%w %d", ErrNegativeAddress, addr)
+	}
+	if addr < len(m.memory) {
+		m.memory[addr] = val
+		return nil
+	}
+	if addr < len(m.memory)+denseGrowLimit {
+		for len(m.memory) <= addr {
+			val, ok := m.sparse[len(m.memory)]
+			if !ok {
+				val = bigZero
+			}
+			delete(m.sparse, len(m.memory))
+			m.memory = append(m.memory, val)
+		}
+		m.memory[addr] = val
+		return nil
+	}
+
+	if val.Sign() == 0 {
+		delete(m.sparse, addr)
+	} else {
+		m.sparse[addr] = val
+	}
+	return nil
+}
+
+// IP returns the instruction pointer
+func (m *BigMachine) IP() int {
+	return m.ip
+}
+
+// RelativeBase returns the relative base
+func (m *BigMachine) RelativeBase() int {
+	return m.relativeBase
+}
+
+// Halted returns true iff the machine has stopped, either by executing a halt instruction or because of an error
+func (m *BigMachine) Halted() bool {
+	return m.halted
+}
+
+// Err returns the error that stopped the machine, if any
+func (m *BigMachine) Err() error {
+	return m.err
+}
+
+// Step executes the instruction at the instruction pointer. If the instruction needs an input and there is none, the
+// instruction pointer is left where it is and StatusNeedsInput is returned
+func (m *BigMachine) Step() (Status, error) {
+	if m.halted {
+		return StatusHalted, ErrHalted
+	}
+
+	val, err := m.Read(m.ip)
+	if err != nil {
+		return StatusHalted, m.fail(0, err)
+	}
+	if !val.IsInt64() {
+		return StatusHalted, m.fail(-1, fmt.Errorf("%w %v", ErrUnknownInstruction, val))
+	}
+	code := int(val.Int64())
+	def, modes, err := DefaultInstructions.decode(code)
+	if err != nil {
+		return StatusHalted, m.fail(code, err)
+	}
+
+	status, err := m.execute(def, modes)
+	if err != nil {
+		return StatusHalted, m.fail(code, err)
+	}
+	return status, nil
+}
+
+// Run executes instructions until the program halts, needs an input that isn't available, has an output, or an error
+// occurs
+func (m *BigMachine) Run() (Status, error) {
+	return m.run(true)
+}
+
+// RunUntilInput executes instructions until the program halts, needs an input that isn't available, or an error
+// occurs, without stopping for outputs
+func (m *BigMachine) RunUntilInput() (Status, error) {
+	return m.run(false)
+}
+
+// run executes instructions until the machine can't continue, or optionally until there is an output
+func (m *BigMachine) run(yieldOutput bool) (Status, error) {
+	for {
+		status, err := m.Step()
+		if err != nil {
+			return status, err
+		}
+
+		switch status {
+		case StatusNeedsInput, StatusHalted:
+			return status, nil
+		case StatusHasOutput:
+			if yieldOutput {
+				return status, nil
+			}
+		}
+	}
+}
+
+// fail stops the machine with an error from the instruction `code`
+func (m *BigMachine) fail(code int, err error) error {
+	m.err = newExecError(DefaultInstructions, m.ip, m.relativeBase, code, err)
+	m.halted = true
+	return m.err
+}
+
+// address returns the address the n-th parameter of the current instruction refers to
+func (m *BigMachine) address(n int, mode Mode) (int, error) {
+	raw, err := m.Read(m.ip + n + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	addr := raw
+	switch mode {
+	case ModePosition:
+	case ModeRelative:
+		addr = new(big.Int).Add(raw, big.NewInt(int64(m.relativeBase)))
+	case ModeImmediate:
+		return 0, ErrImmediateDestination
+	default:
+		return 0, fmt.Errorf("%w %d", ErrInvalidMode, mode)
+	}
+	return bigToInt(addr)
+}
+
+// arg returns the value of the n-th parameter of the current instruction
+func (m *BigMachine) arg(n int, mode Mode) (*big.Int, error) {
+	if mode == ModeImmediate {
+		return m.Read(m.ip + n + 1)
+	}
+	addr, err := m.address(n, mode)
+	if err != nil {
+		return nil, err
+	}
+	return m.Read(addr)
+}
+
+// execute processes a single instruction, moving the instruction pointer on afterwards
+func (m *BigMachine) execute(def *InstructionDef, modes *[maxParameters]Mode) (Status, error) {
+	next := m.ip + def.Arity() + 1
+	status := StatusRunning
+
+	switch instruction := def.Instruction; instruction {
+	case InstructionAdd, InstructionMultiply, InstructionLessThan, InstructionEquals:
+		arg1, err := m.arg(0, modes[0])
+		if err != nil {
+			return status, err
+		}
+		arg2, err := m.arg(1, modes[1])
+		if err != nil {
+			return status, err
+		}
+		dst, err := m.address(2, modes[2])
+		if err != nil {
+			return status, err
+		}
+
+		var val *big.Int
+		switch instruction {
+		case InstructionAdd:
+			val = new(big.Int).Add(arg1, arg2)
+		case InstructionMultiply:
+			val = new(big.Int).Mul(arg1, arg2)
+		case InstructionLessThan:
+			val = big.NewInt(int64(boolToInt(arg1.Cmp(arg2) < 0)))
+		case InstructionEquals:
+			val = big.NewInt(int64(boolToInt(arg1.Cmp(arg2) == 0)))
+		}
+		if err := m.Write(dst, val); err != nil {
+			return status, err
+		}
+
+	case InstructionInput:
+		dst, err := m.address(0, modes[0])
+		if err != nil {
+			return status, err
+		}
+		if len(m.inputs) == 0 {
+			// Leave the instruction pointer here so the input is retried next time
+			return StatusNeedsInput, nil
+		}
+		val := new(big.Int).Set(m.inputs[0])
+		m.inputs = m.inputs[1:]
+		if err := m.Write(dst, val); err != nil {
+			return status, err
+		}
+
+	case InstructionOutput:
+		arg, err := m.arg(0, modes[0])
+		if err != nil {
+			return status, err
+		}
+		m.outputs = append(m.outputs, new(big.Int).Set(arg))
+		status = StatusHasOutput
+
+	case InstructionJumpTrue, InstructionJumpFalse:
+		arg1, err := m.arg(0, modes[0])
+		if err != nil {
+			return status, err
+		}
+		if (arg1.Sign() != 0) == (instruction == InstructionJumpTrue) {
+			target, err := m.arg(1, modes[1])
+			if err != nil {
+				return status, err
+			}
+			if next, err = bigToInt(target); err != nil {
+				return status, err
+			}
+		}
+
+	case InstructionRelativeBaseOffset:
+		arg, err := m.arg(0, modes[0])
+		if err != nil {
+			return status, err
+		}
+		rb, err := bigToInt(new(big.Int).Add(arg, big.NewInt(int64(m.relativeBase))))
+		if err != nil {
+			return status, err
+		}
+		m.relativeBase = rb
+
+	case InstructionHalt:
+		m.halted = true
+		return StatusHalted, nil
+
+	default:
+		return status, fmt.Errorf("%w %d", ErrUnknownInstruction, instruction)
+	}
+
+	m.ip = next
+	return status, nil
+}
+
+// bigToInt converts an address to an int, failing if it is too large
+func bigToInt(n *big.Int) (int, error) {
+	if !n.IsInt64() || int64(int(n.Int64())) != n.Int64() {
+		return 0, fmt.Errorf("%w %v", ErrAddressTooLarge, n)
+	}
+	return int(n.Int64()), nil
+}
@@ -0,0 +1,123 @@
+package opcode_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestBigMachine(t *testing.T) {
+	pow := func(base, exp int64) *big.Int {
+		return new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil)
+	}
+
+	// Squares its input the given number of times, outputting each result
+	square := opcode.MustAssemble(`
+		      in [x]
+		      in [n]
+		loop: mul [x], [x], [x]
+		      out [x]
+		      add [n], #-1, [n]
+		      jt [n], #loop
+		      hlt
+		x:    data 0
+		n:    data 0
+	`)
+
+	cases := map[string]struct {
+		codes    []int
+		inputs   []*big.Int
+		expected []*big.Int
+	}{
+		"Large Product": {
+			codes:    []int{1102, 34915192, 34915192, 7, 4, 7, 99, 0},
+			expected: []*big.Int{big.NewInt(1219070632396864)},
+		},
+		"Large Value": {
+			codes:    []int{104, 1125899906842624, 99},
+			expected: []*big.Int{big.NewInt(1125899906842624)},
+		},
+		"Overflows Int64": {
+			codes:    square,
+			inputs:   []*big.Int{big.NewInt(1 << 32), big.NewInt(3)},
+			expected: []*big.Int{pow(2, 64), pow(2, 128), pow(2, 256)},
+		},
+		"Large Input": {
+			codes:    square,
+			inputs:   []*big.Int{pow(10, 30), big.NewInt(1)},
+			expected: []*big.Int{pow(10, 60)},
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewBigMachine(data.codes)
+		m.PushInput(data.inputs...)
+		status, err := m.RunUntilInput()
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, opcode.StatusHalted, status, "Case %s", name)
+
+		outputs := m.Outputs()
+		require.Lenf(t, outputs, len(data.expected), "Case %s", name)
+		for i, expected := range data.expected {
+			require.Equalf(t, 0, expected.Cmp(outputs[i]), "Case %s: expected %v, got %v", name, expected, outputs[i])
+		}
+	}
+}
+
+func TestBigMachineInput(t *testing.T) {
+	m := opcode.NewBigMachine([]int{3, 7, 4, 7, 1105, 1, 0, 0})
+
+	status, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Equal(t, 0, m.IP())
+
+	m.PushInput(big.NewInt(5))
+	status, err = m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHasOutput, status)
+	require.Equal(t, []*big.Int{big.NewInt(5)}, m.Outputs())
+}
+
+func TestBigMachineErrors(t *testing.T) {
+	cases := map[string]struct {
+		codes    []int
+		inputs   []*big.Int
+		expected error
+	}{
+		"Unknown Instruction": {
+			codes:    []int{42},
+			expected: opcode.ErrUnknownInstruction,
+		},
+		"Jump Too Far": {
+			// Jumps to the address given as input
+			codes:    []int{3, 6, 105, 1, 6, 99, 0},
+			inputs:   []*big.Int{new(big.Int).Lsh(big.NewInt(1), 100)},
+			expected: opcode.ErrAddressTooLarge,
+		},
+		"Negative Address": {
+			codes:    []int{4, -1, 99},
+			expected: opcode.ErrNegativeAddress,
+		},
+		"Immediate Destination": {
+			codes:    []int{11101, 1, 1, 0, 99},
+			expected: opcode.ErrImmediateDestination,
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewBigMachine(data.codes)
+		m.PushInput(data.inputs...)
+		_, err := m.RunUntilInput()
+		require.Truef(t, errors.Is(err, data.expected), "Case %s: %v", name, err)
+
+		var execErr *opcode.ExecError
+		require.Truef(t, errors.As(err, &execErr), "Case %s", name)
+		require.Truef(t, m.Halted(), "Case %s", name)
+		require.Equalf(t, err, m.Err(), "Case %s", name)
+	}
+}
@@ -0,0 +1,212 @@
+package opcode
+
+// compiledOp executes a single instruction that has been compiled, returning the address of the next instruction.
+// It returns false if the instruction has to be interpreted instead, for example because it reads memory outside the
+// dense part. Compiled instructions don't change anything unless they succeed, so the interpreter can always take over
+type compiledOp func(m *Machine) (int, bool)
+
+// compiledCheckInterval is how many compiled instructions are executed between checks for the context being
+// cancelled
+const compiledCheckInterval = 1024
+
+// compiledCode caches the instructions compiled from a memory, by address. Writing to memory forgets any instruction
+// that the address was part of, so self-modifying code is compiled again the next time it runs
+type compiledCode struct {
+	ops []compiledOp
+}
+
+// interpret is the compiled form of an instruction that is always interpreted, such as one that reads input
+func interpret(m *Machine) (int, bool) {
+	return 0, false
+}
+
+// SetCompiled chooses whether Run compiles the program as it runs it. Compiling decodes each instruction once, into a
+// function specialised for its parameters, rather than every time it is executed, which makes long-running programs
+// several times faster. It is on by default, and only used for the standard instructions of machines without hooks or
+// strict mode. Step always interprets the instruction
+func (m *Machine) SetCompiled(compiled bool) {
+	m.compiled = compiled
+}
+
+// compiledCode returns the compiled instructions of the machine's memory, or nil if the machine can't use them
+func (m *Machine) compiledCode() *compiledCode {
+	if !m.compiled || m.hooks != nil || m.strict {
+		return nil
+	}
+	if m.memory.code == nil {
+		m.memory.code = &compiledCode{
+			ops: make([]compiledOp, m.memory.Len()),
+		}
+	}
+	return m.memory.code
+}
+
+// run executes compiled instructions until it reaches one that has to be interpreted, compiling instructions as it
+// goes. It returns false if `done` is closed first
+func (c *compiledCode) run(m *Machine, done <-chan struct{}) bool {
+	for n := 1; ; n++ {
+		if done != nil && n%compiledCheckInterval == 0 {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+		}
+
+		op := c.op(m.ip)
+		if op == nil {
+			op = c.compile(m)
+		}
+		next, ok := op(m)
+		if !ok {
+			return true
+		}
+		m.ip = next
+	}
+}
+
+// op returns the compiled instruction at an address, or nil if it hasn't been compiled
+func (c *compiledCode) op(ip int) compiledOp {
+	if uint(ip) < uint(len(c.ops)) {
+		return c.ops[ip]
+	}
+	return nil
+}
+
+// compile compiles the instruction at the instruction pointer, and keeps it to be used next time
+func (c *compiledCode) compile(m *Machine) compiledOp {
+	ip := m.ip
+	op := compile(m, ip)
+	if ip >= 0 && ip < m.memory.Len() {
+		if ip >= len(c.ops) {
+			c.ops = append(c.ops, make([]compiledOp, m.memory.Len()-len(c.ops))...)
+		}
+		c.ops[ip] = op
+	}
+	return op
+}
+
+// invalidate forgets any compiled instruction that the address was part of
+func (c *compiledCode) invalidate(addr int) {
+	if addr-maxParameters >= len(c.ops) {
+		return
+	}
+	for start := addr - maxParameters; start <= addr; start++ {
+		if start >= 0 && start < len(c.ops) {
+			c.ops[start] = nil
+		}
+	}
+}
+
+// compile compiles the instruction at `ip`. Anything that isn't one of the standard instructions, or that needs the
+// interpreter to do input, output or halting, is left to the interpreter. An instruction set only has the same
+// definitions as DefaultInstructions if it was cloned from it or is a profile, so anything else registered with the
+// same opcode is interpreted
+func compile(m *Machine, ip int) compiledOp {
+	code, err := m.memory.Read(ip)
+	if err != nil {
+		return interpret
+	}
+	def, modes, err := m.instructions.decode(code)
+	if err != nil || def != DefaultInstructions.defs[def.Instruction] {
+		return interpret
+	}
+
+	var raw [maxParameters]int
+	for i := range def.Operands {
+		switch {
+		case modes[i] != ModePosition && modes[i] != ModeImmediate && modes[i] != ModeRelative:
+			return interpret
+		case def.writes(i) && modes[i] == ModeImmediate:
+			return interpret
+		}
+		if raw[i], err = m.memory.Read(ip + i + 1); err != nil {
+			return interpret
+		}
+	}
+	next := ip + len(def.Operands) + 1
+
+	switch def.Instruction {
+	case InstructionAdd, InstructionMultiply, InstructionLessThan, InstructionEquals:
+		return compileBinary(def.Instruction, modes, raw, next)
+	case InstructionJumpTrue, InstructionJumpFalse:
+		return compileJump(def.Instruction == InstructionJumpTrue, modes, raw, next)
+	case InstructionRelativeBaseOffset:
+		mode, offset := modes[0], raw[0]
+		return func(m *Machine) (int, bool) {
+			val, ok := m.load(mode, offset)
+			if !ok {
+				return 0, false
+			}
+			m.relativeBase += val
+			return next, true
+		}
+	}
+	return interpret
+}
+
+// compileBinary compiles an instruction that combines its first two parameters and stores the result in its third
+func compileBinary(instruction Instruction, modes *[maxParameters]Mode, raw [maxParameters]int, next int) compiledOp {
+	modeA, modeB, modeDest := modes[0], modes[1], modes[2]
+	a, b, dest := raw[0], raw[1], raw[2]
+	return func(m *Machine) (int, bool) {
+		x, ok := m.load(modeA, a)
+		if !ok {
+			return 0, false
+		}
+		y, ok := m.load(modeB, b)
+		if !ok {
+			return 0, false
+		}
+
+		var val int
+		switch instruction {
+		case InstructionAdd:
+			val = x + y
+		case InstructionMultiply:
+			val = x * y
+		case InstructionLessThan:
+			val = boolToInt(x < y)
+		default:
+			val = boolToInt(x == y)
+		}
+
+		addr := dest
+		if modeDest == ModeRelative {
+			addr += m.relativeBase
+		}
+		if !m.memory.writeDense(addr, val) {
+			return 0, false
+		}
+		return next, true
+	}
+}
+
+// compileJump compiles an instruction that jumps to its second parameter if its first being non-zero is `when`
+func compileJump(when bool, modes *[maxParameters]Mode, raw [maxParameters]int, next int) compiledOp {
+	modeCond, modeTarget := modes[0], modes[1]
+	cond, target := raw[0], raw[1]
+	return func(m *Machine) (int, bool) {
+		val, ok := m.load(modeCond, cond)
+		if !ok {
+			return 0, false
+		}
+		if (val != 0) != when {
+			return next, true
+		}
+		// A jump to a negative address is left to the interpreter to report
+		addr, ok := m.load(modeTarget, target)
+		return addr, ok && addr >= 0
+	}
+}
+
+// load returns the value of a compiled parameter, or false if it is outside the dense memory
+func (m *Machine) load(mode Mode, raw int) (int, bool) {
+	switch mode {
+	case ModeImmediate:
+		return raw, true
+	case ModeRelative:
+		raw += m.relativeBase
+	}
+	return m.memory.readDense(raw)
+}
@@ -0,0 +1,29 @@
+package opcode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledProfile(t *testing.T) {
+	for _, name := range ProfileNames() {
+		s, err := Profile(name)
+		require.NoErrorf(t, err, "Case %s", name)
+
+		// Adds 1 and 2 and stores the result at address 7, only using instructions and modes every profile has
+		m := NewMachine([]int{1, 5, 6, 7, 99, 1, 2, 0}, nil, nil)
+		m.SetInstructionSet(s)
+		_, err = m.Run()
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, 3, m.memory.Slice()[7], "Case %s", name)
+
+		// The add was compiled, rather than left to the interpreter
+		require.NotNilf(t, m.memory.code, "Case %s", name)
+		op := m.memory.code.op(0)
+		require.NotNilf(t, op, "Case %s", name)
+		next, ok := op(m)
+		require.Truef(t, ok, "Case %s", name)
+		require.Equalf(t, 4, next, "Case %s", name)
+	}
+}
@@ -0,0 +1,119 @@
+package opcode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+	"aoc/utils"
+)
+
+func TestCompiledSelfModifyingCode(t *testing.T) {
+	cases := map[string]struct {
+		src      string
+		expected []int
+	}{
+		"Operand Rewritten": {
+			// After the first time round the loop, the amount added to x changes from 1 to 10
+			src: `
+				loop: add [x], #1, [x]
+				      add #10, #0, [loop+2]
+				      add [n], #-1, [n]
+				      jt [n], #loop
+				      out [x]
+				      hlt
+				x:    data 0
+				n:    data 3
+			`,
+			expected: []int{21},
+		},
+		"Instruction Rewritten": {
+			// After the first time round the loop, x is multiplied by 2 instead of having 2 added to it
+			src: `
+				loop: add [x], #2, [x]
+				      add #1002, #0, [loop]
+				      add [n], #-1, [n]
+				      jt [n], #loop
+				      out [x]
+				      hlt
+				x:    data 1
+				n:    data 3
+			`,
+			expected: []int{12},
+		},
+		"Jump Target Rewritten": {
+			// The jump goes back to the start until n reaches 2, when it is changed to go to the end
+			src: `
+				start: out [n]
+				       add [n], #1, [n]
+				       eq [n], #2, [done]
+				       jf [done], #jump
+				       add #end, #0, [jump+2]
+				jump:  jt #1, #start
+				end:   hlt
+				n:     data 0
+				done:  data 0
+			`,
+			expected: []int{0, 1},
+		},
+	}
+
+	for name, data := range cases {
+		codes := opcode.MustAssemble(data.src)
+
+		interpreted := opcode.NewSyncMachine(append(codes[:0:0], codes...))
+		interpreted.SetCompiled(false)
+		compiled := opcode.NewSyncMachine(append(codes[:0:0], codes...))
+
+		for _, m := range []*opcode.Machine{interpreted, compiled} {
+			status, err := m.RunUntilInput()
+			require.NoErrorf(t, err, "Case %s", name)
+			require.Equalf(t, opcode.StatusHalted, status, "Case %s", name)
+		}
+		outputs := compiled.Outputs()
+		require.Equalf(t, data.expected, outputs, "Case %s", name)
+		require.Equalf(t, interpreted.Outputs(), outputs, "Case %s", name)
+		require.Equalf(t, interpreted.Memory().Slice(), compiled.Memory().Slice(), "Case %s", name)
+		require.Equalf(t, interpreted.IP(), compiled.IP(), "Case %s", name)
+	}
+}
+
+func TestCompiledMemoryWrittenBetweenRuns(t *testing.T) {
+	// Every time it is given an input, adds 5 to 0 and outputs the result. The 5 is at address 3
+	m := opcode.NewSyncMachine([]int{3, 100, 1101, 5, 0, 101, 4, 101, 1105, 1, 0})
+
+	m.PushInput(0)
+	_, err := m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, []int{5}, m.Outputs())
+
+	require.NoError(t, m.Memory().Write(3, 7))
+	m.PushInput(0)
+	_, err = m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, []int{7}, m.Outputs())
+}
+
+// BenchmarkDay9Part2 runs the BOOST program in sensor boost mode, compiled and interpreted
+func BenchmarkDay9Part2(b *testing.B) {
+	input, err := utils.LoadInputFromPath("../inputs/day9.input")
+	if err != nil {
+		b.Skip("No input for Day 9")
+	}
+	codes, err := opcode.Parse(input[0])
+	require.NoError(b, err)
+
+	for name, compiled := range map[string]bool{"Compiled": true, "Interpreted": false} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := opcode.NewMachine(append(codes[:0:0], codes...), opcode.NewSliceInput(2), &opcode.SliceOutput{})
+				m.SetCompiled(compiled)
+				if _, err := m.Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package opcode
 
+import "fmt"
+
 // Mode represents and addressing mode for opcode arguments
 type Mode int
 
@@ -38,16 +40,10 @@ const (
 	InstructionHalt Instruction = 99
 )
 
-// InstructionParameterCount denotes how many arguments each agument accepts
-var InstructionParameterCount = map[Instruction]int{
-	InstructionAdd:                3,
-	InstructionMultiply:           3,
-	InstructionInput:              1,
-	InstructionOutput:             1,
-	InstructionJumpTrue:           2,
-	InstructionJumpFalse:          2,
-	InstructionLessThan:           3,
-	InstructionEquals:             3,
-	InstructionRelativeBaseOffset: 1,
-	InstructionHalt:               0,
+// String returns the mnemonic for the instruction, as defined in DefaultInstructions
+func (i Instruction) String() string {
+	if def, ok := DefaultInstructions.Lookup(i); ok {
+		return def.Mnemonic
+	}
+	return fmt.Sprintf("op%d", int(i))
 }
@@ -0,0 +1,169 @@
+package opcode
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Coverage records which addresses of a program are executed as instructions, read as data, and written, by the
+// machines it is added to. The same Coverage can be added to several machines running the same program, to see what
+// they cover between them
+type Coverage struct {
+	lock sync.Mutex
+	// executed counts how many times the instruction at each address was executed
+	executed map[int]int
+	read     map[int]bool
+	written  map[int]bool
+	// instructions is the instruction set of the machines, used to decode the listing
+	instructions *InstructionSet
+}
+
+// NewCoverage creates a Coverage that hasn't seen anything executed yet
+func NewCoverage() *Coverage {
+	return &Coverage{
+		executed:     map[int]int{},
+		read:         map[int]bool{},
+		written:      map[int]bool{},
+		instructions: DefaultInstructions,
+	}
+}
+
+// Hooks returns the hooks that record coverage, to be added to a machine with AddHooks
+func (c *Coverage) Hooks() Hooks {
+	return Hooks{
+		AfterInstruction: func(m *Machine, ip int, instruction Instruction, operands []Operand) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.executed[ip]++
+			c.instructions = m.instructions
+		},
+		MemoryRead: func(m *Machine, addr, val int) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.read[addr] = true
+		},
+		MemoryWrite: func(m *Machine, addr, val int) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.written[addr] = true
+		},
+	}
+}
+
+// Executed returns how many times the instruction at an address was executed
+func (c *Coverage) Executed(addr int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.executed[addr]
+}
+
+// Read returns true iff an instruction read the value at an address as data
+func (c *Coverage) Read(addr int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.read[addr]
+}
+
+// Written returns true iff an instruction wrote to an address
+func (c *Coverage) Written(addr int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.written[addr]
+}
+
+// CoverageLine is a line of a program listing, with what happened to the addresses on it
+type CoverageLine struct {
+	Line
+	// Executed is how many times the instruction on the line was executed
+	Executed int
+	// Read and Written are true iff any of the addresses on the line were read or written as data
+	Read, Written bool
+}
+
+// Unreached returns true iff the line looks like an instruction, but was never executed or used as data
+func (l CoverageLine) Unreached() bool {
+	return !l.Data && l.Executed == 0 && !l.Read && !l.Written
+}
+
+// String returns the line as it appears in a coverage report: the number of times it was executed, or `-` if it was
+// never reached, whether it was read or written, and then the listing
+func (l CoverageLine) String() string {
+	executed := ""
+	switch {
+	case l.Executed > 0:
+		executed = fmt.Sprintf("%d", l.Executed)
+	case l.Unreached():
+		executed = "-"
+	}
+
+	access := []byte("  ")
+	if l.Read {
+		access[0] = 'R'
+	}
+	if l.Written {
+		access[1] = 'W'
+	}
+	return fmt.Sprintf("%8s %s  %v", executed, access, l.Line)
+}
+
+// Listing disassembles a program in the same way as Disassemble, with the instruction set of the machines that ran it,
+// marking each line with its coverage. The listing follows the addresses that were actually executed, so an instruction
+// is never hidden inside a line of data that happened to decode as an instruction
+func (c *Coverage) Listing(codes []int) []CoverageLine {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	lines := []CoverageLine{}
+	for addr := 0; addr < len(codes); {
+		line, ok := c.instructions.disassembleInstruction(codes, addr)
+		if !ok || c.executed[addr] == 0 && c.executedWithin(addr+1, addr+len(line.Values)) {
+			line = Line{
+				Addr:   addr,
+				Values: codes[addr : addr+1],
+				Data:   true,
+			}
+		}
+
+		covered := CoverageLine{
+			Line:     line,
+			Executed: c.executed[addr],
+		}
+		for i := range line.Values {
+			covered.Read = covered.Read || c.read[addr+i]
+			covered.Written = covered.Written || c.written[addr+i]
+		}
+		lines = append(lines, covered)
+		addr += len(line.Values)
+	}
+	return lines
+}
+
+// WriteReport writes the coverage listing of a program to `w`, followed by a summary of how many of its instructions
+// were executed. Lines that look like instructions but were never reached are marked with `-`
+func (c *Coverage) WriteReport(w io.Writer, codes []int) error {
+	ew := &errWriter{w: w}
+	instructions, executed := 0, 0
+	for _, line := range c.Listing(codes) {
+		ew.printf("%v\n", line)
+		if line.Executed > 0 {
+			executed++
+		}
+		if line.Executed > 0 || line.Unreached() {
+			instructions++
+		}
+	}
+	ew.printf("\nExecuted %d of %d instructions (%s)\n", executed, instructions, percent(executed, instructions))
+	return ew.err
+}
+
+// executedWithin returns true iff an instruction was executed at any address from `start` up to, but not including,
+// `end`
+func (c *Coverage) executedWithin(start, end int) bool {
+	for addr := start; addr < end; addr++ {
+		if c.executed[addr] > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package opcode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestCoverage(t *testing.T) {
+	// Outputs 1 if its input is non-zero, otherwise 0
+	branch := opcode.MustAssemble(`
+		         in [x]
+		         jt [x], #nonzero
+		         out #0
+		         hlt
+		nonzero: out #1
+		         hlt
+		x:       data 0
+	`)
+
+	cases := map[string]struct {
+		codes  []int
+		inputs []int
+		lines  []string
+		report string
+	}{
+		"Branch not taken": {
+			codes:  branch,
+			inputs: []int{0},
+			lines: []string{
+				"       1     0000  3,11                         in [11]",
+				"       1     0002  1005,11,8                    jt [11], #8",
+				"       1     0005  104,0                        out #0",
+				"       1     0007  99                           hlt",
+				"       -     0008  104,1                        out #1",
+				"       -     0010  99                           hlt",
+				"         RW  0011  0                            data 0",
+			},
+			report: "Executed 4 of 6 instructions (66.7%)",
+		},
+		"Branch taken": {
+			codes:  branch,
+			inputs: []int{7},
+			lines: []string{
+				"       1     0000  3,11                         in [11]",
+				"       1     0002  1005,11,8                    jt [11], #8",
+				"       -     0005  104,0                        out #0",
+				"       -     0007  99                           hlt",
+				"       1     0008  104,1                        out #1",
+				"       1     0010  99                           hlt",
+				"         RW  0011  7                            data 7",
+			},
+			report: "Executed 4 of 6 instructions (66.7%)",
+		},
+		"Executed address inside another instruction": {
+			// Disassembling from the start shows a mul at address 3, but the program jumps straight to the hlt at 4
+			codes: []int{1105, 1, 4, 2, 99, 0, 0},
+			lines: []string{
+				"       1     0000  1105,1,4                     jt #1, #4",
+				"             0003  2                            data 2",
+				"       1     0004  99                           hlt",
+				"             0005  0                            data 0",
+				"             0006  0                            data 0",
+			},
+			report: "Executed 2 of 2 instructions (100.0%)",
+		},
+	}
+
+	for name, data := range cases {
+		codes := append(data.codes[:0:0], data.codes...)
+		coverage := opcode.NewCoverage()
+		m := opcode.NewMachine(codes, opcode.NewSliceInput(data.inputs...), &opcode.SliceOutput{})
+		m.AddHooks(coverage.Hooks())
+		_, err := m.Run()
+		require.NoErrorf(t, err, "Case %s", name)
+
+		lines := []string{}
+		for _, line := range coverage.Listing(codes) {
+			lines = append(lines, line.String())
+		}
+		require.Equal(t, data.lines, lines, "Case %s", name)
+
+		buf := &bytes.Buffer{}
+		require.NoErrorf(t, coverage.WriteReport(buf, codes), "Case %s", name)
+		require.Contains(t, buf.String(), data.report, "Case %s", name)
+	}
+}
@@ -0,0 +1,402 @@
+package opcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// debuggerHelp describes the commands the debugger understands
+const debuggerHelp = `Commands:
+  step [n]              (s) execute n instructions, 1 by default
+  continue              (c) run until a breakpoint, the program needs input, or it halts
+  break <addr>          (b) stop before executing the instruction at addr
+  break op <mnemonic>   (b) stop before executing any instruction of the given kind, e.g. "break op out"
+  delete <addr>         (d) remove a breakpoint on an address
+  delete op <mnemonic>  (d) remove a breakpoint on an instruction
+  breakpoints           list the breakpoints
+  list [addr] [n]       (l) disassemble n instructions from addr, the IP by default
+  mem <addr> [n]        (x) print n values of memory from addr
+  set <addr> <value>    write a value to memory
+  ip [addr]             print or move the instruction pointer
+  rb [value]            print or set the relative base
+  input <value>...      (i) give values to the program's input
+  state                 print the IP, relative base and status
+  save <path>           write a snapshot of the machine to a file
+  load <path>           replace the machine with one restored from a snapshot file
+  help                  (h) print this help
+  quit                  (q) exit the debugger`
+
+// Debugger lets a machine be stepped through interactively, with breakpoints and memory inspection
+type Debugger struct {
+	m      *Machine
+	status Status
+
+	breakpoints            map[int]bool
+	instructionBreakpoints map[Instruction]bool
+}
+
+// NewDebugger creates a new Debugger for a program. The program runs on a machine created by NewSyncMachine, so
+// inputs can be given to it from the debugger prompt
+func NewDebugger(codes []int) *Debugger {
+	return &Debugger{
+		m:                      NewSyncMachine(codes),
+		status:                 StatusRunning,
+		breakpoints:            map[int]bool{},
+		instructionBreakpoints: map[Instruction]bool{},
+	}
+}
+
+// Machine returns the machine being debugged
+func (d *Debugger) Machine() *Machine {
+	return d.m
+}
+
+// Run reads commands from `r`, one per line, writing their results to `w` until the input ends or the quit command is
+// given
+func (d *Debugger) Run(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	fmt.Fprintln(w, d.m.instructions.DisassembleAt(d.m.memory, d.m.ip))
+
+	for {
+		fmt.Fprint(w, "(intcode) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return scanner.Err()
+		}
+
+		quit, err := d.Exec(scanner.Text(), w)
+		if err != nil {
+			fmt.Fprintf(w, "Error: %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec runs a single debugger command, writing its result to `w`. It returns true if the command was to quit
+func (d *Debugger) Exec(command string, w io.Writer) (bool, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	args := fields[1:]
+
+	switch fields[0] {
+	case "step", "s":
+		n, err := optionalInt(args, 0, 1)
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < n; i++ {
+			if err := d.step(w); err != nil || d.status != StatusRunning {
+				return false, err
+			}
+		}
+		fmt.Fprintln(w, d.m.instructions.DisassembleAt(d.m.memory, d.m.ip))
+
+	case "continue", "c":
+		return false, d.cont(w)
+
+	case "break", "b":
+		return false, d.setBreakpoint(args, true, w)
+
+	case "delete", "d":
+		return false, d.setBreakpoint(args, false, w)
+
+	case "breakpoints":
+		d.printBreakpoints(w)
+
+	case "list", "l":
+		addr, err := optionalInt(args, 0, d.m.ip)
+		if err != nil {
+			return false, err
+		}
+		n, err := optionalInt(args, 1, 10)
+		if err != nil {
+			return false, err
+		}
+		for i := 0; i < n; i++ {
+			line := d.m.instructions.DisassembleAt(d.m.memory, addr)
+			marker := "  "
+			if addr == d.m.ip {
+				marker = "=>"
+			} else if d.breakpoints[addr] {
+				marker = "* "
+			}
+			fmt.Fprintf(w, "%s %s\n", marker, line)
+			addr += len(line.Values)
+		}
+
+	case "mem", "x":
+		if len(args) == 0 {
+			return false, fmt.Errorf("mem needs an address")
+		}
+		addr, err := optionalInt(args, 0, 0)
+		if err != nil {
+			return false, err
+		}
+		n, err := optionalInt(args, 1, 1)
+		if err != nil {
+			return false, err
+		}
+		for i := addr; i < addr+n; i++ {
+			val, err := d.m.memory.Read(i)
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(w, "%04d: %d\n", i, val)
+		}
+
+	case "set":
+		if len(args) != 2 {
+			return false, fmt.Errorf("set needs an address and a value")
+		}
+		addr, err := strconv.Atoi(args[0])
+		if err != nil {
+			return false, err
+		}
+		val, err := strconv.Atoi(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, d.m.memory.Write(addr, val)
+
+	case "ip":
+		if len(args) > 0 {
+			ip, err := strconv.Atoi(args[0])
+			if err != nil {
+				return false, err
+			}
+			d.m.SetIP(ip)
+		}
+		fmt.Fprintln(w, d.m.instructions.DisassembleAt(d.m.memory, d.m.ip))
+
+	case "rb":
+		if len(args) > 0 {
+			rb, err := strconv.Atoi(args[0])
+			if err != nil {
+				return false, err
+			}
+			d.m.SetRelativeBase(rb)
+		}
+		fmt.Fprintf(w, "Relative base: %d\n", d.m.relativeBase)
+
+	case "input", "i":
+		if len(args) == 0 {
+			return false, fmt.Errorf("input needs at least one value")
+		}
+		values := make([]int, len(args))
+		for i, arg := range args {
+			val, err := strconv.Atoi(arg)
+			if err != nil {
+				return false, err
+			}
+			values[i] = val
+		}
+		d.m.PushInput(values...)
+		if d.status == StatusNeedsInput {
+			d.status = StatusRunning
+		}
+
+	case "state":
+		fmt.Fprintf(w, "IP: %d\nRelative base: %d\nStatus: %v\n", d.m.ip, d.m.relativeBase, d.status)
+		if d.m.err != nil {
+			fmt.Fprintf(w, "Error: %v\n", d.m.err)
+		}
+
+	case "save":
+		if len(args) != 1 {
+			return false, fmt.Errorf("save needs a path")
+		}
+		return false, d.save(args[0])
+
+	case "load":
+		if len(args) != 1 {
+			return false, fmt.Errorf("load needs a path")
+		}
+		if err := d.load(args[0]); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(w, d.m.instructions.DisassembleAt(d.m.memory, d.m.ip))
+
+	case "help", "h":
+		fmt.Fprintln(w, debuggerHelp)
+
+	case "quit", "q":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("Unknown command %q, try \"help\"", fields[0])
+	}
+	return false, nil
+}
+
+// step executes a single instruction, reporting any output or change of status
+func (d *Debugger) step(w io.Writer) error {
+	if d.m.halted {
+		d.status = StatusHalted
+		return ErrHalted
+	}
+
+	status, err := d.m.Step()
+	for _, output := range d.m.Outputs() {
+		fmt.Fprintf(w, "Output: %d\n", output)
+	}
+	if err != nil {
+		d.status = StatusHalted
+		return err
+	}
+
+	switch status {
+	case StatusNeedsInput:
+		d.status = status
+		fmt.Fprintln(w, "Waiting for input, use \"input <value>\"")
+	case StatusHalted:
+		d.status = status
+		fmt.Fprintln(w, "Program halted")
+	default:
+		d.status = StatusRunning
+	}
+	return nil
+}
+
+// cont runs the program until it reaches a breakpoint or can't continue
+func (d *Debugger) cont(w io.Writer) error {
+	// Always execute the current instruction, so continuing from a breakpoint doesn't stop straight away
+	for first := true; ; first = false {
+		if !first && d.atBreakpoint() {
+			fmt.Fprintf(w, "Breakpoint\n%v\n", d.m.instructions.DisassembleAt(d.m.memory, d.m.ip))
+			return nil
+		}
+		if err := d.step(w); err != nil || d.status != StatusRunning {
+			return err
+		}
+	}
+}
+
+// atBreakpoint returns true iff the instruction at the IP has a breakpoint on it
+func (d *Debugger) atBreakpoint() bool {
+	if d.breakpoints[d.m.ip] {
+		return true
+	}
+	code, err := d.m.memory.Read(d.m.ip)
+	if err != nil || code < 0 {
+		return false
+	}
+	return d.instructionBreakpoints[Instruction(code%100)]
+}
+
+// setBreakpoint adds or removes a breakpoint on an address or an instruction
+func (d *Debugger) setBreakpoint(args []string, set bool, w io.Writer) error {
+	if len(args) == 2 && args[0] == "op" {
+		var instruction Instruction
+		if def, ok := d.m.instructions.LookupMnemonic(strings.ToLower(args[1])); ok {
+			instruction = def.Instruction
+		} else {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("Unknown instruction %q", args[1])
+			}
+			instruction = Instruction(n)
+		}
+		if set {
+			d.instructionBreakpoints[instruction] = true
+		} else {
+			delete(d.instructionBreakpoints, instruction)
+		}
+		return nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("Expected an address, or op and an instruction")
+	}
+	addr, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	if set {
+		d.breakpoints[addr] = true
+	} else {
+		delete(d.breakpoints, addr)
+	}
+	return nil
+}
+
+// printBreakpoints lists all the breakpoints, in order
+func (d *Debugger) printBreakpoints(w io.Writer) {
+	addrs := []int{}
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "Address %d\n", addr)
+	}
+
+	instructions := []int{}
+	for instruction := range d.instructionBreakpoints {
+		instructions = append(instructions, int(instruction))
+	}
+	sort.Ints(instructions)
+	for _, instruction := range instructions {
+		fmt.Fprintf(w, "Instruction %v\n", Instruction(instruction))
+	}
+}
+
+// save writes a snapshot of the machine to a file
+func (d *Debugger) save(path string) error {
+	s, err := d.m.Snapshot()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// load replaces the machine with one restored from a snapshot file. Breakpoints, the instruction set and strict mode
+// are kept
+func (d *Debugger) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s, err := LoadSnapshot(f)
+	if err != nil {
+		return err
+	}
+	m, err := Restore(s)
+	if err != nil {
+		return err
+	}
+	m.SetInstructionSet(d.m.instructions)
+	m.SetStrict(d.m.strict)
+
+	d.m, d.status = m, StatusRunning
+	if m.halted {
+		d.status = StatusHalted
+	}
+	return nil
+}
+
+// optionalInt parses the i-th argument as a number, or returns the default if there aren't that many arguments
+func optionalInt(args []string, i, def int) (int, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	return strconv.Atoi(args[i])
+}
@@ -0,0 +1,160 @@
+package opcode_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestDebugger(t *testing.T) {
+	codes := opcode.MustAssemble(`
+		in [20]
+		add [20], #1, [21]
+		out [21]
+		out [20]
+		hlt
+	`)
+
+	cases := map[string]struct {
+		commands []string
+		expected []string
+		ip       int
+		memory   map[int]int
+	}{
+		"Step": {
+			commands: []string{"step", "input 4", "s 3"},
+			expected: []string{"Waiting for input", "Output: 5", "0008  4,20"},
+			ip:       8,
+			memory:   map[int]int{20: 4, 21: 5},
+		},
+		"Breakpoint On Address": {
+			commands: []string{"input 1", "break 6", "continue", "continue"},
+			expected: []string{"Breakpoint\n0006  4,21", "Output: 2\nOutput: 1\nProgram halted"},
+			ip:       10,
+			memory:   map[int]int{20: 1, 21: 2},
+		},
+		"Breakpoint On Instruction": {
+			commands: []string{"i 7", "b op out", "c", "c", "delete op out", "c"},
+			expected: []string{"Breakpoint\n0006", "Output: 8\nBreakpoint\n0008", "Output: 7\nProgram halted"},
+			ip:       10,
+		},
+		"Edit Memory And Registers": {
+			commands: []string{"set 20 41", "mem 20 2", "ip 2", "rb 7", "s", "state"},
+			expected: []string{"0020: 41\n0021: 0", "0002  1001,20,1,21", "Relative base: 7", "IP: 6\nRelative base: 7\nStatus: Running"},
+			ip:       6,
+			memory:   map[int]int{20: 41, 21: 42},
+		},
+		"List": {
+			commands: []string{"b 2", "list 0 3"},
+			expected: []string{"=> 0000  3,20", "*  0002  1001,20,1,21", "   0006  4,21"},
+			ip:       0,
+		},
+		"Bad Command": {
+			commands: []string{"jump 5", "break", "mem"},
+			expected: []string{"Error: Unknown command \"jump\"", "Error: Expected an address", "Error: mem needs an address"},
+			ip:       0,
+		},
+	}
+
+	for name, data := range cases {
+		d := opcode.NewDebugger(append(codes[:0:0], codes...))
+		out := &bytes.Buffer{}
+		require.NoErrorf(t, d.Run(strings.NewReader(strings.Join(data.commands, "\n")), out), "Case %s", name)
+
+		for _, expected := range data.expected {
+			require.Containsf(t, out.String(), expected, "Case %s", name)
+		}
+		require.Equalf(t, data.ip, d.Machine().IP(), "Case %s", name)
+		for addr, expected := range data.memory {
+			val, err := d.Machine().Memory().Read(addr)
+			require.NoErrorf(t, err, "Case %s", name)
+			require.Equalf(t, expected, val, "Case %s", name)
+		}
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	d := opcode.NewDebugger([]int{99})
+	quit, err := d.Exec("quit", &bytes.Buffer{})
+	require.NoError(t, err)
+	require.True(t, quit)
+}
+
+func TestDebuggerSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debugger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	codes := opcode.MustAssemble(`
+		in [20]
+		add [20], #1, [21]
+		out [21]
+		hlt
+	`)
+
+	d := opcode.NewDebugger(codes)
+	out := &bytes.Buffer{}
+	for _, command := range []string{"input 4", "s 2", "save " + path, "s 2"} {
+		_, err := d.Exec(command, out)
+		require.NoError(t, err)
+	}
+	require.Contains(t, out.String(), "Output: 5\n")
+
+	// Loading goes back to just before the output
+	out.Reset()
+	for _, command := range []string{"load " + path, "c"} {
+		_, err := d.Exec(command, out)
+		require.NoError(t, err)
+	}
+	require.Equal(t, "0006  4,21                         out [21]\nOutput: 5\nProgram halted\n", out.String())
+}
+
+func TestDebuggerLoadKeepsSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debugger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	const maxInt = int(^uint(0) >> 1)
+	cases := map[string]struct {
+		codes []int
+		err   error
+	}{
+		"Profile": {
+			// Immediate mode isn't part of the day 2 profile
+			codes: []int{1101, 1, 2, 5, 99, 0},
+			err:   opcode.ErrInvalidMode,
+		},
+		"Strict": {
+			codes: []int{1, 5, 6, 7, 99, maxInt, 1, 0},
+			err:   opcode.ErrOverflow,
+		},
+	}
+
+	for name, data := range cases {
+		s, err := opcode.Profile("day2")
+		require.NoErrorf(t, err, "Case %s", name)
+		d := opcode.NewDebugger(data.codes)
+		d.Machine().SetInstructionSet(s)
+		d.Machine().SetStrict(true)
+
+		out := &bytes.Buffer{}
+		for _, command := range []string{"save " + path, "load " + path} {
+			_, err := d.Exec(command, out)
+			require.NoErrorf(t, err, "Case %s", name)
+		}
+		require.Equalf(t, s, d.Machine().InstructionSet(), "Case %s", name)
+
+		_, err = d.Exec("step", out)
+		require.Truef(t, errors.Is(err, data.err), "Case %s: %v", name, err)
+	}
+}
@@ -0,0 +1,41 @@
+package opcode
+
+import "fmt"
+
+// maxParameters is the largest number of parameters any instruction takes
+const maxParameters = 3
+
+// modeTable maps the mode digits of an instruction (the value divided by 100) to the mode of each parameter
+var modeTable [1000][maxParameters]Mode
+
+func init() {
+	for digits := range modeTable {
+		n := digits
+		for i := 0; i < maxParameters; i++ {
+			modeTable[digits][i] = Mode(n % 10)
+			n /= 10
+		}
+	}
+}
+
+// decode splits an instruction value into the definition of the instruction and the mode of each parameter, using
+// integer arithmetic and lookup tables so that nothing is allocated
+func (s *InstructionSet) decode(n int) (*InstructionDef, *[maxParameters]Mode, error) {
+	if n < 0 {
+		return nil, nil, fmt.Errorf("%w %d", ErrUnknownInstruction, n)
+	}
+
+	def := s.defs[n%100]
+	if def == nil {
+		return nil, nil, fmt.Errorf("%w %d", ErrUnknownInstruction, n%100)
+	}
+	modes := &modeTable[(n/100)%1000]
+	if s.modes != 0 {
+		for i := range def.Operands {
+			if s.modes&(1<<uint(modes[i])) == 0 {
+				return nil, nil, fmt.Errorf("%w %d", ErrInvalidMode, modes[i])
+			}
+		}
+	}
+	return def, modes, nil
+}
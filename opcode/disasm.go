@@ -0,0 +1,177 @@
+package opcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operand is a parameter of an instruction
+type Operand struct {
+	// Mode is the addressing mode of the parameter
+	Mode Mode
+	// Raw is the value of the parameter as it appears in the program
+	Raw int
+
+	// Addr is the address the parameter refers to, for position and relative mode parameters
+	Addr int
+	// Value is the value read from the parameter, or the value written to it
+	Value int
+	// Resolved is true once a machine has read from or written to the parameter. Operands from the disassembler are
+	// never resolved
+	Resolved bool
+}
+
+// String formats the operand using the sigil for its mode: `[12]` for position mode, `#5` for immediate mode and
+// `rb+3` for relative mode
+func (o Operand) String() string {
+	switch o.Mode {
+	case ModePosition:
+		return fmt.Sprintf("[%d]", o.Raw)
+	case ModeImmediate:
+		return fmt.Sprintf("#%d", o.Raw)
+	case ModeRelative:
+		if o.Raw < 0 {
+			return fmt.Sprintf("rb%d", o.Raw)
+		}
+		return fmt.Sprintf("rb+%d", o.Raw)
+	default:
+		return fmt.Sprintf("?%d", o.Raw)
+	}
+}
+
+// Line is a single line of a disassembled program. It is either an instruction with its operands, or a single value
+// that couldn't be decoded as an instruction
+type Line struct {
+	// Addr is the address of the first value on the line
+	Addr int
+	// Values are the raw values the line was decoded from
+	Values []int
+	// Data is true if the value isn't a valid instruction
+	Data bool
+	// Instruction is the decoded instruction, if this isn't data
+	Instruction Instruction
+	// Mnemonic is the name of the instruction in the instruction set it was decoded with
+	Mnemonic string
+	// Operands are the decoded parameters of the instruction
+	Operands []Operand
+}
+
+// Asm returns the line as Intcode assembly, e.g. `mul [4], #3, [4]` or `data 33`
+func (l Line) Asm() string {
+	if l.Data {
+		return fmt.Sprintf("data %d", l.Values[0])
+	}
+
+	operands := make([]string, len(l.Operands))
+	for i, operand := range l.Operands {
+		operands[i] = operand.String()
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s", l.Mnemonic, strings.Join(operands, ", ")))
+}
+
+// String returns the line as it appears in a listing: the address, the raw values and the assembly
+func (l Line) String() string {
+	values := make([]string, len(l.Values))
+	for i, val := range l.Values {
+		values[i] = strconv.Itoa(val)
+	}
+	return fmt.Sprintf("%04d  %-28s %s", l.Addr, strings.Join(values, ","), l.Asm())
+}
+
+// Disassemble decodes a program using DefaultInstructions, in the same way as InstructionSet.Disassemble
+func Disassemble(codes []int) []Line {
+	return DefaultInstructions.Disassemble(codes)
+}
+
+// Disassemble decodes a program into a listing of the instructions in the set. Values that can't be decoded as an
+// instruction, such as unknown opcodes, invalid modes, or instructions that would run off the end of the program, are
+// listed as data and decoding carries on from the next address
+func (s *InstructionSet) Disassemble(codes []int) []Line {
+	lines := []Line{}
+	for addr := 0; addr < len(codes); {
+		line, ok := s.disassembleInstruction(codes, addr)
+		if !ok {
+			line = Line{
+				Addr:   addr,
+				Values: codes[addr : addr+1],
+				Data:   true,
+			}
+		}
+		lines = append(lines, line)
+		addr += len(line.Values)
+	}
+	return lines
+}
+
+// DisassembleAt decodes the single instruction at the given address of a machine's memory. If the value there isn't
+// an instruction in the set it is returned as data
+func (s *InstructionSet) DisassembleAt(mem *Memory, addr int) Line {
+	words := make([]int, maxParameters+1)
+	for i := range words {
+		words[i], _ = mem.Read(addr + i)
+	}
+
+	line, ok := s.disassembleInstruction(words, 0)
+	if !ok {
+		line = Line{
+			Values: words[:1],
+			Data:   true,
+		}
+	}
+	line.Addr = addr
+	return line
+}
+
+// disassembleInstruction decodes the instruction at the given address, returning false if it isn't valid
+func (s *InstructionSet) disassembleInstruction(codes []int, addr int) (Line, bool) {
+	code := codes[addr]
+	if code < 0 || code >= 100000 {
+		return Line{}, false
+	}
+	def, modes, err := s.decode(code)
+	if err != nil {
+		return Line{}, false
+	}
+
+	count := def.Arity()
+	if addr+count >= len(codes) {
+		return Line{}, false
+	}
+
+	// Every mode digit beyond the instruction's parameters must be 0
+	if code/100 >= pow10(count) {
+		return Line{}, false
+	}
+
+	operands := make([]Operand, count)
+	for i := range operands {
+		if modes[i] != ModePosition && modes[i] != ModeImmediate && modes[i] != ModeRelative {
+			return Line{}, false
+		}
+		if def.writes(i) && modes[i] == ModeImmediate {
+			return Line{}, false
+		}
+		operands[i] = Operand{
+			Mode: modes[i],
+			Raw:  codes[addr+i+1],
+		}
+	}
+
+	return Line{
+		Addr:        addr,
+		Values:      codes[addr : addr+count+1],
+		Instruction: def.Instruction,
+		Mnemonic:    def.Mnemonic,
+		Operands:    operands,
+	}, true
+}
+
+// pow10 returns 10 to the power of n
+func pow10(n int) int {
+	res := 1
+	for i := 0; i < n; i++ {
+		res *= 10
+	}
+	return res
+}
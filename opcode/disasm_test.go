@@ -0,0 +1,68 @@
+package opcode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestDisassemble(t *testing.T) {
+	cases := map[string]struct {
+		codes    []int
+		expected []string
+	}{
+		"Modes": {
+			codes: []int{1002, 4, 3, 4, 22201, 1, -1, -2, 99},
+			expected: []string{
+				"mul [4], #3, [4]",
+				"add rb+1, rb-1, rb-2",
+				"hlt",
+			},
+		},
+		"Data After Halt": {
+			codes: []int{104, 7, 99, 33, -4},
+			expected: []string{
+				"out #7",
+				"hlt",
+				"data 33",
+				"data -4",
+			},
+		},
+		"Invalid Instructions": {
+			codes: []int{42, 301, 11101, 99, 1099, 5},
+			expected: []string{
+				"data 42",
+				"data 301",
+				"data 11101",
+				"hlt",
+				"data 1099",
+				"data 5",
+			},
+		},
+		"Truncated Instruction": {
+			codes: []int{3, 0, 1, 0},
+			expected: []string{
+				"in [0]",
+				"data 1",
+				"data 0",
+			},
+		},
+	}
+
+	for name, data := range cases {
+		asm := []string{}
+		for _, line := range opcode.Disassemble(data.codes) {
+			asm = append(asm, line.Asm())
+		}
+		require.Equalf(t, data.expected, asm, "Case %s", name)
+	}
+}
+
+func TestDisassembleListing(t *testing.T) {
+	lines := opcode.Disassemble([]int{1002, 4, 3, 4, 33})
+	require.Len(t, lines, 2)
+	require.Equal(t, "0000  1002,4,3,4                   mul [4], #3, [4]", lines[0].String())
+	require.Equal(t, "0004  33                           data 33", lines[1].String())
+}
@@ -0,0 +1,175 @@
+package opcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnknownInstruction is the cause of an ExecError when the opcode isn't a known instruction
+	ErrUnknownInstruction = errors.New("Unknown instruction")
+	// ErrInvalidMode is the cause of an ExecError when a parameter has a mode that doesn't exist
+	ErrInvalidMode = errors.New("Invalid mode")
+	// ErrImmediateDestination is the cause of an ExecError when a parameter that is written to is in immediate mode
+	ErrImmediateDestination = errors.New("Immediate mode used as destination")
+	// ErrNegativeAddress is the cause of an ExecError when an instruction reads or writes a negative address
+	ErrNegativeAddress = errors.New("Negative address")
+	// ErrOverflow is the cause of an ExecError when a strict machine adds or multiplies numbers whose result doesn't fit
+	// in an int
+	ErrOverflow = errors.New("Integer overflow")
+	// ErrJumpOutOfRange is the cause of an ExecError when a strict machine jumps to an address outside the program it was
+	// loaded with
+	ErrJumpOutOfRange = errors.New("Jump target outside loaded memory")
+	// ErrAddressTooLarge is the cause of an ExecError when a BigMachine uses an address, jump target or relative base
+	// too large to fit in an int
+	ErrAddressTooLarge = errors.New("Address too large")
+	// ErrDeadlock is matched by a DeadlockError, which is returned when every machine in a group that hasn't halted is
+	// waiting for input, and none is on its way
+	ErrDeadlock = errors.New("Every machine is waiting for input")
+)
+
+// ExecError is returned when a machine fails to execute an instruction. It records the state of the machine at the
+// point the instruction failed
+type ExecError struct {
+	// IP is the address of the instruction that failed
+	IP int
+	// Opcode is the raw value of the instruction, including the parameter modes
+	Opcode int
+	// Instruction is the decoded instruction
+	Instruction Instruction
+	// Modes are the decoded modes of the instruction's parameters
+	Modes []Mode
+	// RelativeBase is the relative base when the instruction was executed
+	RelativeBase int
+	// Operands are the instruction's parameters, as far as they were resolved before it failed. They are only recorded
+	// by strict machines
+	Operands []Operand
+	// Err is the underlying cause of the failure
+	Err error
+
+	// def is the definition of the instruction, if it is known
+	def *InstructionDef
+}
+
+// Error returns a description of the failure and the state of the machine
+func (e *ExecError) Error() string {
+	if e.Operands != nil && e.def != nil {
+		return fmt.Sprintf("Error executing %s (opcode %d, modes %v) at IP %d with relative base %d: %v",
+			formatTrace(e.def, e.Operands), e.Opcode, e.Modes, e.IP, e.RelativeBase, e.Err)
+	}
+	return fmt.Sprintf("Error executing %v (opcode %d, modes %v) at IP %d with relative base %d: %v",
+		e.Instruction, e.Opcode, e.Modes, e.IP, e.RelativeBase, e.Err)
+}
+
+// Unwrap returns the underlying cause of the failure
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// execError wraps an error from the instruction at the instruction pointer in an ExecError
+func (m *Machine) execError(code int, err error) error {
+	return newExecError(m.instructions, m.ip, m.relativeBase, code, err)
+}
+
+// newExecError wraps an error from the instruction `code` at `ip` in an ExecError. Cancellation of the context is
+// returned as-is so callers can compare it with ctx.Err()
+func newExecError(s *InstructionSet, ip, relativeBase, code int, err error) error {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	instruction := Instruction(code % 100)
+	modes := []Mode{}
+	def, ok := s.Lookup(instruction)
+	if code >= 0 && ok && len(def.Operands) > 0 {
+		modes = make([]Mode, len(def.Operands))
+		copy(modes, modeTable[(code/100)%1000][:])
+	}
+
+	return &ExecError{
+		def:          def,
+		IP:           ip,
+		Opcode:       code,
+		Instruction:  instruction,
+		Modes:        modes,
+		RelativeBase: relativeBase,
+		Err:          err,
+	}
+}
+
+// Waiting describes a machine that is stuck waiting for input
+type Waiting struct {
+	// ID identifies the machine in its group
+	ID int
+	// IP is the address of the input instruction the machine is stuck on
+	IP int
+	// Instruction is the input instruction, as assembly
+	Instruction string
+	// Sources are the machines connected to this one that are still running, which could have given it input
+	Sources []int
+	// Halted are the machines connected to this one that have halted, so won't give it any more input
+	Halted []int
+}
+
+// String describes what the machine is waiting for
+func (w Waiting) String() string {
+	waitingFor := "has nothing connected to it"
+	switch {
+	case len(w.Sources) > 0:
+		waitingFor = fmt.Sprintf("is waiting for machine %s", joinIDs(w.Sources, " or "))
+	case len(w.Halted) == 1:
+		waitingFor = fmt.Sprintf("won't get any more input, machine %d has halted", w.Halted[0])
+	case len(w.Halted) > 1:
+		waitingFor = fmt.Sprintf("won't get any more input, machines %s have halted", joinIDs(w.Halted, " and "))
+	}
+	return fmt.Sprintf("machine %d at IP %d (%s) %s", w.ID, w.IP, w.Instruction, waitingFor)
+}
+
+// joinIDs formats a list of machine IDs, separated by `sep`
+func joinIDs(ids []int, sep string) string {
+	formatted := make([]string, len(ids))
+	for i, id := range ids {
+		formatted[i] = fmt.Sprintf("%d", id)
+	}
+	return strings.Join(formatted, sep)
+}
+
+// newWaiting describes a machine that is waiting for input from its sources, splitting them into those still running
+// and those that have halted
+func newWaiting(id int, m *Machine, sources []int, halted func(source int) bool) Waiting {
+	w := Waiting{
+		ID:          id,
+		IP:          m.ip,
+		Instruction: m.instructions.DisassembleAt(m.memory, m.ip).Asm(),
+	}
+	for _, source := range sources {
+		if halted(source) {
+			w.Halted = append(w.Halted, source)
+		} else {
+			w.Sources = append(w.Sources, source)
+		}
+	}
+	return w
+}
+
+// DeadlockError is returned when every machine in a group that hasn't halted is waiting for input, and none is on its
+// way. It lists what each of the machines is waiting for
+type DeadlockError struct {
+	Waiting []Waiting
+}
+
+// Error returns a description of the deadlock, listing each machine that is waiting
+func (e *DeadlockError) Error() string {
+	waiting := make([]string, len(e.Waiting))
+	for i, w := range e.Waiting {
+		waiting[i] = w.String()
+	}
+	return fmt.Sprintf("%v: %s", ErrDeadlock, strings.Join(waiting, "; "))
+}
+
+// Is returns true iff the target is ErrDeadlock, so that errors.Is can be used to check for any deadlock
+func (e *DeadlockError) Is(target error) bool {
+	return target == ErrDeadlock
+}
@@ -0,0 +1,98 @@
+package opcode
+
+// Hooks are functions that a machine calls as it executes a program, so that it can be observed without changing
+// how it runs. Any of the functions can be nil
+type Hooks struct {
+	// BeforeInstruction is called before an instruction is executed. The operands have been decoded, but not yet
+	// resolved
+	BeforeInstruction func(m *Machine, ip int, instruction Instruction, operands []Operand)
+	// AfterInstruction is called once an instruction has been executed, with the operands the instruction resolved.
+	// It isn't called if the instruction failed, or if it is waiting for an input
+	AfterInstruction func(m *Machine, ip int, instruction Instruction, operands []Operand)
+	// MemoryRead is called whenever an instruction reads a value from memory
+	MemoryRead func(m *Machine, addr, val int)
+	// MemoryWrite is called whenever an instruction writes a value to memory
+	MemoryWrite func(m *Machine, addr, val int)
+	// Input is called with every value the machine reads from its input
+	Input func(m *Machine, val int)
+	// Output is called with every value the machine writes to its output
+	Output func(m *Machine, val int)
+}
+
+// defaultHooks are added to every machine when it is created
+var defaultHooks []Hooks
+
+// AddDefaultHooks adds hooks to every machine created from now on. It is intended to be called once at start up, for
+// example to trace every machine from a command line flag
+func AddDefaultHooks(h Hooks) {
+	defaultHooks = append(defaultHooks, h)
+}
+
+// AddHooks adds hooks to the machine. Hooks are called in the order they were added
+func (m *Machine) AddHooks(h Hooks) {
+	m.hooks = append(m.hooks, h)
+}
+
+// beforeInstruction decodes the operands of the current instruction and calls the BeforeInstruction hooks
+func (m *Machine) beforeInstruction(def *InstructionDef) {
+	count := len(def.Operands)
+	for i := 0; i < count; i++ {
+		raw, _ := m.memory.Read(m.ip + i + 1)
+		m.operands[i] = Operand{
+			Mode: m.modes[i],
+			Raw:  raw,
+		}
+	}
+
+	for _, h := range m.hooks {
+		if h.BeforeInstruction != nil {
+			h.BeforeInstruction(m, m.ip, def.Instruction, m.operands[:count])
+		}
+	}
+}
+
+// afterInstruction calls the AfterInstruction hooks for the instruction that was at `ip`
+func (m *Machine) afterInstruction(ip int, def *InstructionDef) {
+	operands := m.operands[:len(def.Operands)]
+	for _, h := range m.hooks {
+		if h.AfterInstruction != nil {
+			h.AfterInstruction(m, ip, def.Instruction, operands)
+		}
+	}
+}
+
+// memoryRead calls the MemoryRead hooks
+func (m *Machine) memoryRead(addr, val int) {
+	for _, h := range m.hooks {
+		if h.MemoryRead != nil {
+			h.MemoryRead(m, addr, val)
+		}
+	}
+}
+
+// memoryWrite calls the MemoryWrite hooks
+func (m *Machine) memoryWrite(addr, val int) {
+	for _, h := range m.hooks {
+		if h.MemoryWrite != nil {
+			h.MemoryWrite(m, addr, val)
+		}
+	}
+}
+
+// input calls the Input hooks
+func (m *Machine) input(val int) {
+	for _, h := range m.hooks {
+		if h.Input != nil {
+			h.Input(m, val)
+		}
+	}
+}
+
+// output calls the Output hooks
+func (m *Machine) output(val int) {
+	for _, h := range m.hooks {
+		if h.Output != nil {
+			h.Output(m, val)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package opcode_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestHooks(t *testing.T) {
+	codes := opcode.MustAssemble(`
+		in [a]
+		arb #2
+		add [a], #1, rb+13
+		out rb+13
+		jf #1, #0
+		hlt
+		a: data 0
+		b: data 0
+	`)
+
+	events := []string{}
+	m := opcode.NewMachine(codes, opcode.NewSliceInput(4), &opcode.SliceOutput{})
+	m.AddHooks(opcode.Hooks{
+		BeforeInstruction: func(m *opcode.Machine, ip int, instruction opcode.Instruction, operands []opcode.Operand) {
+			events = append(events, fmt.Sprintf("before %d %v %d", ip, instruction, len(operands)))
+		},
+		AfterInstruction: func(m *opcode.Machine, ip int, instruction opcode.Instruction, operands []opcode.Operand) {
+			events = append(events, fmt.Sprintf("after %d %v", ip, instruction))
+		},
+		MemoryRead: func(m *opcode.Machine, addr, val int) {
+			events = append(events, fmt.Sprintf("read %d=%d", addr, val))
+		},
+		MemoryWrite: func(m *opcode.Machine, addr, val int) {
+			events = append(events, fmt.Sprintf("write %d=%d", addr, val))
+		},
+		Input: func(m *opcode.Machine, val int) {
+			events = append(events, fmt.Sprintf("input %d", val))
+		},
+		Output: func(m *opcode.Machine, val int) {
+			events = append(events, fmt.Sprintf("output %d", val))
+		},
+	})
+
+	_, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"before 0 in 1", "input 4", "write 14=4", "after 0 in",
+		"before 2 arb 1", "after 2 arb",
+		"before 4 add 3", "read 14=4", "write 15=5", "after 4 add",
+		"before 8 out 1", "read 15=5", "output 5", "after 8 out",
+		"before 10 jf 2", "after 10 jf",
+		"before 13 hlt 0", "after 13 hlt",
+	}, events)
+}
+
+func TestTracer(t *testing.T) {
+	codes := opcode.MustAssemble(`
+		in [20]
+		arb #5
+		add [20], #1, rb+16
+		out rb+16
+		jf #1, #0
+		hlt
+	`)
+
+	buf := &bytes.Buffer{}
+	m := opcode.NewMachine(codes, opcode.NewSliceInput(4), &opcode.SliceOutput{})
+	m.AddHooks(opcode.NewTracer(buf))
+
+	_, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, ""+
+		"0000  rb=0     in [20]<-4\n"+
+		"0002  rb=0     arb #5\n"+
+		"0004  rb=5     add [20]=4, #1, rb+16(21)<-5\n"+
+		"0008  rb=5     out rb+16(21)=5\n"+
+		"0010  rb=5     jf #1, #0\n"+
+		"0013  rb=5     hlt\n", buf.String())
+}
@@ -0,0 +1,375 @@
+package opcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrReadOnlyInstructions is returned when changing an instruction set that can't be changed, such as
+// DefaultInstructions
+var ErrReadOnlyInstructions = errors.New("Instruction set is read-only")
+
+// OperandKind says how an instruction uses one of its parameters
+type OperandKind int
+
+const (
+	// OperandRead is a parameter whose value is read by the instruction
+	OperandRead OperandKind = iota
+	// OperandWrite is a parameter giving the address the instruction writes its result to. It can't be in immediate
+	// mode
+	OperandWrite
+)
+
+// Handler executes an instruction. The machine has already decoded the instruction, and the handler resolves its
+// parameters through `e`. Returning StatusNeedsInput leaves the instruction pointer where it is so that the instruction
+// is retried, and returning StatusHalted stops the machine
+type Handler func(e *Exec) (Status, error)
+
+// InstructionDef describes an instruction a machine can execute
+type InstructionDef struct {
+	// Instruction is the opcode of the instruction, from 0 to 99
+	Instruction Instruction
+	// Mnemonic is the short name of the instruction, used when reading and writing Intcode assembly
+	Mnemonic string
+	// Operands says how each of the instruction's parameters is used. An instruction can have up to 3 parameters
+	Operands []OperandKind
+	// Handler executes the instruction
+	Handler Handler
+}
+
+// Arity returns the number of parameters the instruction takes
+func (d *InstructionDef) Arity() int {
+	return len(d.Operands)
+}
+
+// writes returns true iff the n-th parameter is written to
+func (d *InstructionDef) writes(n int) bool {
+	return n < len(d.Operands) && d.Operands[n] == OperandWrite
+}
+
+// InstructionSet is a set of instructions that a machine understands
+type InstructionSet struct {
+	defs [100]*InstructionDef
+	// modes has a bit set for each parameter mode the set allows, or is 0 if any mode is allowed
+	modes uint
+	// readOnly is true if the set can't be changed, so it is safe to share between goroutines
+	readOnly bool
+}
+
+// DefaultInstructions holds the standard instructions. It is used by new machines, and by Assemble and Disassemble. It
+// is shared by every machine, so it can't be changed. To add instructions, Clone it, register them with the clone, and
+// give the clone to the machines that need it with SetInstructionSet
+var DefaultInstructions = standardInstructions()
+
+// NewInstructionSet creates a new InstructionSet containing the given instructions
+func NewInstructionSet(defs ...InstructionDef) (*InstructionSet, error) {
+	s := &InstructionSet{}
+	for _, def := range defs {
+		if err := s.Register(def); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Register adds an instruction to the set. It fails if the opcode or mnemonic is already used, or the definition isn't
+// valid
+func (s *InstructionSet) Register(def InstructionDef) error {
+	switch {
+	case s.readOnly:
+		return ErrReadOnlyInstructions
+	case def.Instruction < 0 || int(def.Instruction) >= len(s.defs):
+		return fmt.Errorf("Opcode %d must be between 0 and %d", def.Instruction, len(s.defs)-1)
+	case s.defs[def.Instruction] != nil:
+		return fmt.Errorf("Opcode %d is already defined as %s", def.Instruction, s.defs[def.Instruction].Mnemonic)
+	case !isLabel(def.Mnemonic) || strings.ToLower(def.Mnemonic) != def.Mnemonic:
+		return fmt.Errorf("Invalid mnemonic %q for opcode %d", def.Mnemonic, def.Instruction)
+	case len(def.Operands) > maxParameters:
+		return fmt.Errorf("%s has %d operands, but instructions can have at most %d", def.Mnemonic, len(def.Operands), maxParameters)
+	case def.Handler == nil:
+		return fmt.Errorf("%s has no handler", def.Mnemonic)
+	}
+	if existing, ok := s.LookupMnemonic(def.Mnemonic); ok {
+		return fmt.Errorf("Mnemonic %s is already used by opcode %d", def.Mnemonic, existing.Instruction)
+	}
+
+	def.Operands = append([]OperandKind(nil), def.Operands...)
+	s.defs[def.Instruction] = &def
+	return nil
+}
+
+// RestrictModes limits the parameter modes that instructions in the set can use. Executing an instruction with any
+// other mode fails with ErrInvalidMode
+func (s *InstructionSet) RestrictModes(modes ...Mode) error {
+	if s.readOnly {
+		return ErrReadOnlyInstructions
+	}
+	s.modes = 0
+	for _, mode := range modes {
+		s.modes |= 1 << uint(mode)
+	}
+	return nil
+}
+
+// Clone returns a copy of the set, so that instructions can be added to it without changing the original. The copy
+// can always be changed, even if the original can't
+func (s *InstructionSet) Clone() *InstructionSet {
+	c := *s
+	c.readOnly = false
+	return &c
+}
+
+// Lookup returns the definition of an instruction
+func (s *InstructionSet) Lookup(instruction Instruction) (*InstructionDef, bool) {
+	if instruction < 0 || int(instruction) >= len(s.defs) || s.defs[instruction] == nil {
+		return nil, false
+	}
+	return s.defs[instruction], true
+}
+
+// LookupMnemonic returns the definition of the instruction with the given mnemonic
+func (s *InstructionSet) LookupMnemonic(mnemonic string) (*InstructionDef, bool) {
+	for _, def := range s.defs {
+		if def != nil && def.Mnemonic == mnemonic {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+// Exec gives an instruction handler access to the machine executing the instruction
+type Exec struct {
+	m   *Machine
+	ctx context.Context
+	// next is the address of the instruction to execute afterwards
+	next int
+	// jumped is true if the instruction jumped, even if it jumped to the instruction after it
+	jumped bool
+	// checked records which parameters have been checked as destinations, one bit per parameter
+	checked uint
+}
+
+// Machine returns the machine executing the instruction
+func (e *Exec) Machine() *Machine {
+	return e.m
+}
+
+// Context returns the context the instruction is being executed with
+func (e *Exec) Context() context.Context {
+	return e.ctx
+}
+
+// Arg returns the value of the n-th parameter
+func (e *Exec) Arg(n int) (int, error) {
+	return e.m.arg(n)
+}
+
+// Dest checks the n-th parameter can be written to, without writing anything yet. Store does this itself, so Dest is
+// only needed to report a bad destination before doing anything else
+func (e *Exec) Dest(n int) error {
+	if err := e.m.dst(n); err != nil {
+		return err
+	}
+	e.checked |= 1 << uint(n)
+	return nil
+}
+
+// Store writes a value to the address the n-th parameter refers to
+func (e *Exec) Store(n, val int) error {
+	if e.checked&(1<<uint(n)) == 0 {
+		if err := e.Dest(n); err != nil {
+			return err
+		}
+	}
+	return e.m.store(n, val)
+}
+
+// Input reads a value from the machine's input. It returns false if no input is available yet, in which case the
+// handler should return StatusNeedsInput so that the instruction is retried
+func (e *Exec) Input() (int, bool, error) {
+	if e.m.in == nil {
+		return 0, false, nil
+	}
+	val, err := e.m.in.Read(e.ctx)
+	if err == ErrNoInput {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if e.m.hooks != nil {
+		e.m.input(val)
+	}
+	return val, true, nil
+}
+
+// Output writes a value to the machine's output
+func (e *Exec) Output(val int) error {
+	if e.m.out == nil {
+		return ErrNoOutput
+	}
+	if e.m.hooks != nil {
+		e.m.output(val)
+	}
+	return e.m.out.Write(e.ctx, val)
+}
+
+// Jump makes the machine continue from the given address, instead of the instruction after this one
+func (e *Exec) Jump(addr int) {
+	e.next, e.jumped = addr, true
+}
+
+// standardInstructions returns the instructions of the finished Intcode computer
+func standardInstructions() *InstructionSet {
+	read, write := OperandRead, OperandWrite
+
+	s, err := NewInstructionSet(
+		InstructionDef{InstructionAdd, "add", []OperandKind{read, read, write}, execAdd},
+		InstructionDef{InstructionMultiply, "mul", []OperandKind{read, read, write}, execMultiply},
+		InstructionDef{InstructionInput, "in", []OperandKind{write}, execInput},
+		InstructionDef{InstructionOutput, "out", []OperandKind{read}, execOutput},
+		InstructionDef{InstructionJumpTrue, "jt", []OperandKind{read, read}, execJumpTrue},
+		InstructionDef{InstructionJumpFalse, "jf", []OperandKind{read, read}, execJumpFalse},
+		InstructionDef{InstructionLessThan, "lt", []OperandKind{read, read, write}, execLessThan},
+		InstructionDef{InstructionEquals, "eq", []OperandKind{read, read, write}, execEquals},
+		InstructionDef{InstructionRelativeBaseOffset, "arb", []OperandKind{read}, execRelativeBaseOffset},
+		InstructionDef{InstructionHalt, "hlt", nil, execHalt},
+	)
+	if err != nil {
+		panic(err)
+	}
+	s.readOnly = true
+	return s
+}
+
+// execBinary executes an instruction that combines its first two parameters and stores the result in its third
+func execBinary(e *Exec, f func(m *Machine, a, b int) (int, error)) (Status, error) {
+	a, err := e.Arg(0)
+	if err != nil {
+		return StatusRunning, err
+	}
+	b, err := e.Arg(1)
+	if err != nil {
+		return StatusRunning, err
+	}
+	if err := e.Dest(2); err != nil {
+		return StatusRunning, err
+	}
+
+	val, err := f(e.m, a, b)
+	if err != nil {
+		return StatusRunning, err
+	}
+	return StatusRunning, e.Store(2, val)
+}
+
+func execAdd(e *Exec) (Status, error) {
+	return execBinary(e, add)
+}
+
+func execMultiply(e *Exec) (Status, error) {
+	return execBinary(e, multiply)
+}
+
+func execLessThan(e *Exec) (Status, error) {
+	return execBinary(e, lessThan)
+}
+
+func execEquals(e *Exec) (Status, error) {
+	return execBinary(e, equals)
+}
+
+func add(m *Machine, a, b int) (int, error) {
+	val := a + b
+	if m.strict && (a > 0 && b > 0 && val < 0 || a < 0 && b < 0 && val >= 0) {
+		return 0, ErrOverflow
+	}
+	return val, nil
+}
+
+func multiply(m *Machine, a, b int) (int, error) {
+	val := a * b
+	if m.strict && a != 0 && (val/a != b || a == -1 && b == minInt) {
+		return 0, ErrOverflow
+	}
+	return val, nil
+}
+
+func lessThan(m *Machine, a, b int) (int, error) {
+	return boolToInt(a < b), nil
+}
+
+func equals(m *Machine, a, b int) (int, error) {
+	return boolToInt(a == b), nil
+}
+
+func execInput(e *Exec) (Status, error) {
+	if err := e.Dest(0); err != nil {
+		return StatusRunning, err
+	}
+	val, ok, err := e.Input()
+	if err != nil {
+		return StatusRunning, err
+	}
+	if !ok {
+		return StatusNeedsInput, nil
+	}
+	return StatusRunning, e.Store(0, val)
+}
+
+func execOutput(e *Exec) (Status, error) {
+	val, err := e.Arg(0)
+	if err != nil {
+		return StatusRunning, err
+	}
+	if err := e.Output(val); err != nil {
+		return StatusRunning, err
+	}
+	return StatusHasOutput, nil
+}
+
+func execJumpTrue(e *Exec) (Status, error) {
+	return execJump(e, true)
+}
+
+func execJumpFalse(e *Exec) (Status, error) {
+	return execJump(e, false)
+}
+
+// execJump jumps to the second parameter if the first parameter being non-zero is `when`
+func execJump(e *Exec, when bool) (Status, error) {
+	cond, err := e.Arg(0)
+	if err != nil {
+		return StatusRunning, err
+	}
+	if (cond != 0) == when {
+		target, err := e.Arg(1)
+		if err != nil {
+			return StatusRunning, err
+		}
+		e.Jump(target)
+	}
+	return StatusRunning, nil
+}
+
+func execRelativeBaseOffset(e *Exec) (Status, error) {
+	offset, err := e.Arg(0)
+	if err != nil {
+		return StatusRunning, err
+	}
+	e.m.relativeBase += offset
+	return StatusRunning, nil
+}
+
+func execHalt(e *Exec) (Status, error) {
+	return StatusHalted, nil
+}
+
+// boolToInt converts true to 1 and false to 0
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
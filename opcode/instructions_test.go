@@ -0,0 +1,187 @@
+package opcode_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+// withSubtract returns a copy of the default instruction set with a subtract instruction added as opcode 10
+func withSubtract(t *testing.T) *opcode.InstructionSet {
+	s := opcode.DefaultInstructions.Clone()
+	err := s.Register(opcode.InstructionDef{
+		Instruction: 10,
+		Mnemonic:    "sub",
+		Operands:    []opcode.OperandKind{opcode.OperandRead, opcode.OperandRead, opcode.OperandWrite},
+		Handler: func(e *opcode.Exec) (opcode.Status, error) {
+			a, err := e.Arg(0)
+			if err != nil {
+				return opcode.StatusRunning, err
+			}
+			b, err := e.Arg(1)
+			if err != nil {
+				return opcode.StatusRunning, err
+			}
+			return opcode.StatusRunning, e.Store(2, a-b)
+		},
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestCustomInstruction(t *testing.T) {
+	codes := []int{1110, 50, 8, 7, 4, 7, 99, 0}
+
+	m := opcode.NewSyncMachine(append(codes[:0:0], codes...))
+	m.SetInstructionSet(withSubtract(t))
+	trace := &bytes.Buffer{}
+	m.AddHooks(opcode.NewTracer(trace))
+
+	status, err := m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+	require.Equal(t, []int{42}, m.Outputs())
+	require.Contains(t, trace.String(), "0000  rb=0     sub #50, #8, [7]<-42\n")
+
+	// The default instruction set is unchanged
+	_, err = opcode.NewSyncMachine(codes).Run()
+	require.Error(t, err)
+	_, ok := opcode.DefaultInstructions.Lookup(10)
+	require.False(t, ok)
+}
+
+func TestCustomInstructionControlFlow(t *testing.T) {
+	s := opcode.DefaultInstructions.Clone()
+
+	// Reads an input, then jumps to the address in its parameter, or halts if there's no input
+	err := s.Register(opcode.InstructionDef{
+		Instruction: 20,
+		Mnemonic:    "injmp",
+		Operands:    []opcode.OperandKind{opcode.OperandRead},
+		Handler: func(e *opcode.Exec) (opcode.Status, error) {
+			target, err := e.Arg(0)
+			if err != nil {
+				return opcode.StatusRunning, err
+			}
+			val, ok, err := e.Input()
+			if err != nil || !ok {
+				return opcode.StatusHalted, err
+			}
+			if err := e.Output(val * 2); err != nil {
+				return opcode.StatusRunning, err
+			}
+			e.Jump(target)
+			return opcode.StatusHasOutput, nil
+		},
+	})
+	require.NoError(t, err)
+
+	m := opcode.NewSyncMachine([]int{120, 0})
+	m.SetInstructionSet(s)
+	m.PushInput(1, 2, 3)
+	status, err := m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+	require.Equal(t, []int{2, 4, 6}, m.Outputs())
+	require.Equal(t, 0, m.IP())
+}
+
+func TestRegisterInstruction(t *testing.T) {
+	handler := func(e *opcode.Exec) (opcode.Status, error) {
+		return opcode.StatusRunning, nil
+	}
+
+	cases := map[string]struct {
+		def      opcode.InstructionDef
+		expected string
+	}{
+		"Opcode Too Large": {
+			def:      opcode.InstructionDef{Instruction: 100, Mnemonic: "big", Handler: handler},
+			expected: "Opcode 100 must be between 0 and 99",
+		},
+		"Opcode Taken": {
+			def:      opcode.InstructionDef{Instruction: opcode.InstructionAdd, Mnemonic: "plus", Handler: handler},
+			expected: "Opcode 1 is already defined as add",
+		},
+		"Mnemonic Taken": {
+			def:      opcode.InstructionDef{Instruction: 10, Mnemonic: "add", Handler: handler},
+			expected: "Mnemonic add is already used by opcode 1",
+		},
+		"Invalid Mnemonic": {
+			def:      opcode.InstructionDef{Instruction: 10, Mnemonic: "Sub", Handler: handler},
+			expected: "Invalid mnemonic \"Sub\" for opcode 10",
+		},
+		"Too Many Operands": {
+			def: opcode.InstructionDef{
+				Instruction: 10,
+				Mnemonic:    "sub",
+				Operands:    make([]opcode.OperandKind, 4),
+				Handler:     handler,
+			},
+			expected: "sub has 4 operands, but instructions can have at most 3",
+		},
+		"No Handler": {
+			def:      opcode.InstructionDef{Instruction: 10, Mnemonic: "sub"},
+			expected: "sub has no handler",
+		},
+	}
+
+	for name, data := range cases {
+		err := opcode.DefaultInstructions.Clone().Register(data.def)
+		require.EqualErrorf(t, err, data.expected, "Case %s", name)
+	}
+}
+
+func TestDefaultInstructions(t *testing.T) {
+	def, ok := opcode.DefaultInstructions.LookupMnemonic("eq")
+	require.True(t, ok)
+	require.Equal(t, opcode.InstructionEquals, def.Instruction)
+	require.Equal(t, 3, def.Arity())
+	require.Equal(t, []opcode.OperandKind{opcode.OperandRead, opcode.OperandRead, opcode.OperandWrite}, def.Operands)
+
+	def, ok = opcode.DefaultInstructions.Lookup(opcode.InstructionHalt)
+	require.True(t, ok)
+	require.Equal(t, "hlt", def.Mnemonic)
+	require.Equal(t, 0, def.Arity())
+}
+
+func TestDefaultInstructionsReadOnly(t *testing.T) {
+	handler := func(e *opcode.Exec) (opcode.Status, error) {
+		return opcode.StatusRunning, nil
+	}
+	def := opcode.InstructionDef{Instruction: 10, Mnemonic: "nop", Handler: handler}
+
+	require.Equal(t, opcode.ErrReadOnlyInstructions, opcode.DefaultInstructions.Register(def))
+	require.Equal(t, opcode.ErrReadOnlyInstructions, opcode.DefaultInstructions.RestrictModes(opcode.ModePosition))
+	_, ok := opcode.DefaultInstructions.Lookup(10)
+	require.False(t, ok)
+
+	// A clone can be changed
+	s := opcode.DefaultInstructions.Clone()
+	require.NoError(t, s.Register(def))
+	require.NoError(t, s.RestrictModes(opcode.ModePosition))
+}
+
+func TestCustomInstructionAssembly(t *testing.T) {
+	s := withSubtract(t)
+	src := "sub #50, #8, [7]\nout [7]\nhlt\ndata 0"
+
+	codes, err := s.Assemble(src)
+	require.NoError(t, err)
+	require.Equal(t, []int{1110, 50, 8, 7, 4, 7, 99, 0}, codes)
+
+	asm := []string{}
+	for _, line := range s.Disassemble(codes) {
+		asm = append(asm, line.Asm())
+	}
+	require.Equal(t, strings.Split(src, "\n"), asm)
+
+	// The default instructions don't include it
+	_, err = opcode.Assemble(src)
+	require.EqualError(t, err, "Line 1: unknown mnemonic \"sub\"")
+	require.Equal(t, "data 1110", opcode.Disassemble(codes)[0].Asm())
+}
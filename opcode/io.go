@@ -0,0 +1,284 @@
+package opcode
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrNoInput is returned by an Input when it has no value to give
+var ErrNoInput = errors.New("No input available")
+
+// Input provides values to the input instructions of an Intcode machine
+type Input interface {
+	// Read returns the next input value, blocking until one is available or the context is cancelled
+	Read(ctx context.Context) (int, error)
+}
+
+// Output receives the values from the output instructions of an Intcode machine. If an Output also implements
+// io.Closer it is closed when the machine stops
+type Output interface {
+	// Write sends a value to the output, blocking until it has been accepted or the context is cancelled
+	Write(ctx context.Context, val int) error
+}
+
+// ChanInput is an Input that receives values from a channel
+type ChanInput chan int
+
+// Read receives the next value from the channel
+func (c ChanInput) Read(ctx context.Context) (int, error) {
+	select {
+	case val := <-c:
+		return val, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// ChanOutput is an Output that sends values to a channel. The channel is closed when the machine stops
+type ChanOutput chan int
+
+// Write sends a value to the channel
+func (c ChanOutput) Write(ctx context.Context, val int) error {
+	select {
+	case c <- val:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the channel
+func (c ChanOutput) Close() error {
+	if c != nil {
+		close(c)
+	}
+	return nil
+}
+
+// SliceInput is an Input that gives a fixed list of values, in order. Once all of them have been read it returns
+// ErrNoInput
+type SliceInput struct {
+	values []int
+}
+
+// NewSliceInput creates a new SliceInput that gives the provided values
+func NewSliceInput(values ...int) *SliceInput {
+	return &SliceInput{
+		values: values,
+	}
+}
+
+// Read returns the next value
+func (s *SliceInput) Read(ctx context.Context) (int, error) {
+	if len(s.values) == 0 {
+		return 0, ErrNoInput
+	}
+	val := s.values[0]
+	s.values = s.values[1:]
+	return val, nil
+}
+
+// Push adds values to the end of the list
+func (s *SliceInput) Push(values ...int) {
+	s.values = append(s.values, values...)
+}
+
+// Len returns how many values are still to be read
+func (s *SliceInput) Len() int {
+	return len(s.values)
+}
+
+// SliceOutput is an Output that collects every value it is given
+type SliceOutput struct {
+	Values []int
+}
+
+// Write adds a value to the end of the collected values
+func (s *SliceOutput) Write(ctx context.Context, val int) error {
+	s.Values = append(s.Values, val)
+	return nil
+}
+
+// Last returns the most recently written value, or false if there have been no values
+func (s *SliceOutput) Last() (int, bool) {
+	if len(s.Values) == 0 {
+		return 0, false
+	}
+	return s.Values[len(s.Values)-1], true
+}
+
+// InputFunc is an Input that calls a function to get each value
+type InputFunc func(ctx context.Context) (int, error)
+
+// Read calls the function
+func (f InputFunc) Read(ctx context.Context) (int, error) {
+	return f(ctx)
+}
+
+// OutputFunc is an Output that calls a function with each value
+type OutputFunc func(ctx context.Context, val int) error
+
+// Write calls the function
+func (f OutputFunc) Write(ctx context.Context, val int) error {
+	return f(ctx, val)
+}
+
+// Queue is an unbounded first-in first-out queue that can be used as both an Input and an Output, so writes never
+// block. It is safe to use from multiple goroutines, which makes it suitable for connecting machines together
+type Queue struct {
+	lock   sync.Mutex
+	values []int
+	closed bool
+	ready  chan struct{}
+}
+
+// NewQueue creates a new Queue holding the given values
+func NewQueue(values ...int) *Queue {
+	return &Queue{
+		values: values,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Read removes the value at the front of the queue, blocking until there is one. Once the queue has been closed and
+// emptied it returns io.EOF
+func (q *Queue) Read(ctx context.Context) (int, error) {
+	for {
+		q.lock.Lock()
+		if len(q.values) > 0 {
+			val := q.values[0]
+			q.values = q.values[1:]
+			if len(q.values) > 0 {
+				// Pass the wake up on to any other reader
+				q.notify()
+			}
+			q.lock.Unlock()
+			return val, nil
+		}
+		closed := q.closed
+		q.lock.Unlock()
+
+		if closed {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Write adds a value to the back of the queue
+func (q *Queue) Write(ctx context.Context, val int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return errors.New("Write to closed queue")
+	}
+	q.values = append(q.values, val)
+	q.notify()
+	return nil
+}
+
+// Close marks the queue as finished. Values already in the queue can still be read
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.notify()
+	return nil
+}
+
+// Len returns the number of values waiting in the queue
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.values)
+}
+
+// notify wakes up a blocked reader, if there is one. The lock must be held
+func (q *Queue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// ASCIIInput is an Input that reads text from an io.Reader, giving each byte as a separate value
+type ASCIIInput struct {
+	r *bufio.Reader
+	// pending receives the result of a read from the reader that is still in progress, if there is one
+	pending chan asciiRead
+}
+
+// asciiRead is the result of reading a byte for an ASCIIInput
+type asciiRead struct {
+	b   byte
+	err error
+}
+
+// NewASCIIInput creates a new ASCIIInput reading from `r`
+func NewASCIIInput(r io.Reader) *ASCIIInput {
+	return &ASCIIInput{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Read returns the next byte from the reader. If the reader blocks, the read carries on in the background so that
+// cancelling the context returns straight away, and the byte it reads is returned by the next call to Read
+func (a *ASCIIInput) Read(ctx context.Context) (int, error) {
+	if a.pending == nil {
+		if a.r.Buffered() > 0 {
+			b, _ := a.r.ReadByte()
+			return int(b), nil
+		}
+
+		pending := make(chan asciiRead, 1)
+		go func() {
+			b, err := a.r.ReadByte()
+			pending <- asciiRead{b: b, err: err}
+		}()
+		a.pending = pending
+	}
+
+	select {
+	case res := <-a.pending:
+		a.pending = nil
+		if res.err != nil {
+			return 0, res.err
+		}
+		return int(res.b), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// ASCIIOutput is an Output that writes each value to an io.Writer as an ASCII character. Values outside of the ASCII
+// range are written as numbers on a line of their own
+type ASCIIOutput struct {
+	w io.Writer
+}
+
+// NewASCIIOutput creates a new ASCIIOutput writing to `w`
+func NewASCIIOutput(w io.Writer) *ASCIIOutput {
+	return &ASCIIOutput{
+		w: w,
+	}
+}
+
+// Write writes the value to the writer
+func (a *ASCIIOutput) Write(ctx context.Context, val int) error {
+	var err error
+	if val >= 0 && val <= 127 {
+		_, err = a.w.Write([]byte{byte(val)})
+	} else {
+		_, err = fmt.Fprintf(a.w, "%d\n", val)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package opcode_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestRunWithInputsAndOutputs(t *testing.T) {
+	// Reads two values and outputs their sum, then their product
+	codes := opcode.MustAssemble(`
+		in [a]
+		in [b]
+		add [a], [b], [res]
+		out [res]
+		mul [a], [b], [res]
+		out [res]
+		hlt
+		a:   data 0
+		b:   data 0
+		res: data 0
+	`)
+
+	cases := map[string]struct {
+		in  func() opcode.Input
+		out func(*[]int) opcode.Output
+	}{
+		"Slice": {
+			in: func() opcode.Input { return opcode.NewSliceInput(3, 4) },
+			out: func(res *[]int) opcode.Output {
+				return opcode.OutputFunc(func(ctx context.Context, val int) error {
+					*res = append(*res, val)
+					return nil
+				})
+			},
+		},
+		"Func": {
+			in: func() opcode.Input {
+				next := 2
+				return opcode.InputFunc(func(ctx context.Context) (int, error) {
+					next++
+					return next, nil
+				})
+			},
+			out: func(res *[]int) opcode.Output {
+				return opcode.OutputFunc(func(ctx context.Context, val int) error {
+					*res = append(*res, val)
+					return nil
+				})
+			},
+		},
+		"Queue": {
+			in: func() opcode.Input { return opcode.NewQueue(3, 4) },
+			out: func(res *[]int) opcode.Output {
+				return opcode.OutputFunc(func(ctx context.Context, val int) error {
+					*res = append(*res, val)
+					return nil
+				})
+			},
+		},
+	}
+
+	for name, data := range cases {
+		outputs := []int{}
+		m := opcode.NewMachine(append(codes[:0:0], codes...), data.in(), data.out(&outputs))
+		_, err := m.Run()
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, []int{7, 12}, outputs, "Case %s", name)
+	}
+}
+
+func TestSliceInputExhausted(t *testing.T) {
+	out := &opcode.SliceOutput{}
+	m := opcode.NewMachine([]int{3, 0, 3, 0, 99}, opcode.NewSliceInput(1), out)
+	status, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Equal(t, 2, m.IP())
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	q := opcode.NewQueue(1)
+
+	// Writes never block, however many values there are
+	for i := 2; i <= 1000; i++ {
+		require.NoError(t, q.Write(ctx, i))
+	}
+	require.Equal(t, 1000, q.Len())
+
+	// A blocked reader is woken by a write from another goroutine
+	empty := opcode.NewQueue()
+	go empty.Write(ctx, 42)
+	val, err := empty.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 42, val)
+
+	// Closing lets the remaining values be read, then gives EOF
+	require.NoError(t, q.Close())
+	for i := 1; i <= 1000; i++ {
+		val, err := q.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, i, val)
+	}
+	_, err = q.Read(ctx)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestASCIIInputOutput(t *testing.T) {
+	// Echoes three characters, then outputs a large number
+	codes := []int{3, 100, 4, 100, 3, 100, 4, 100, 3, 100, 4, 100, 104, 1000, 99}
+	buf := &bytes.Buffer{}
+
+	m := opcode.NewMachine(codes, opcode.NewASCIIInput(strings.NewReader("hi\n")), opcode.NewASCIIOutput(buf))
+	_, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, "hi\n1000\n", buf.String())
+}
+
+func TestASCIIInputCancel(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	in := opcode.NewASCIIInput(r)
+
+	// Cancelling stops a read that is blocked on the reader
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := in.Read(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// The byte the abandoned read was waiting for isn't lost
+	go w.Write([]byte("ab"))
+	val, err := in.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int('a'), val)
+	val, err = in.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int('b'), val)
+
+	// A machine blocked on input stops when its context is cancelled
+	m := opcode.NewMachine([]int{3, 0, 99}, opcode.NewASCIIInput(r), nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.RunContext(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
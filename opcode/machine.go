@@ -0,0 +1,461 @@
+package opcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrHalted is returned when trying to step a machine that has already halted
+var ErrHalted = errors.New("Machine has halted")
+
+// ErrNoOutput is the cause of an ExecError when a machine without an output executes an output instruction
+var ErrNoOutput = errors.New("Machine has no output")
+
+// ErrCloneIO is returned when cloning a machine whose input or output can't be copied
+var ErrCloneIO = errors.New("Can't clone input or output")
+
+// Status describes the state a machine is in after executing instructions
+type Status int
+
+const (
+	// StatusRunning means the machine can carry on executing instructions
+	StatusRunning Status = iota
+	// StatusNeedsInput means the machine is waiting at an input instruction, but there was no input available
+	StatusNeedsInput
+	// StatusHasOutput means the machine has just executed an output instruction
+	StatusHasOutput
+	// StatusHalted means the machine has stopped and won't execute any more instructions
+	StatusHalted
+)
+
+// String returns a human-readable description of the status
+func (s Status) String() string {
+	switch s {
+	case StatusRunning:
+		return "Running"
+	case StatusNeedsInput:
+		return "NeedsInput"
+	case StatusHasOutput:
+		return "HasOutput"
+	case StatusHalted:
+		return "Halted"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// Machine is an Intcode computer. It owns the program memory, the instruction pointer and the relative base, so a
+// program can be inspected between instructions or resumed later
+type Machine struct {
+	memory       *Memory
+	ip           int
+	relativeBase int
+
+	halted bool
+	err    error
+	strict bool
+
+	instructions *InstructionSet
+	// compiled is true if Run may compile the program
+	compiled bool
+	// exec is passed to the handler of the instruction being executed
+	exec Exec
+
+	// modes holds the parameter modes of the instruction being executed, and operands holds its parameters as they
+	// are resolved
+	modes    [maxParameters]Mode
+	operands [maxParameters]Operand
+
+	hooks []Hooks
+	// hookRelativeBase is the relative base before the instruction being executed, for the hooks to see afterwards
+	hookRelativeBase int
+
+	in  Input
+	out Output
+
+	// Synchronous machines buffer their inputs and outputs, and return from Run whenever there is an output
+	inputs  *SliceInput
+	outputs *SliceOutput
+}
+
+// NewMachine creates a new Machine that runs the given program, reading inputs from `in` and writing outputs to `out`.
+// The machine takes ownership of `codes` and modifies it in place as the program runs
+func NewMachine(codes []int, in Input, out Output) *Machine {
+	return &Machine{
+		memory:       NewMemory(codes),
+		instructions: DefaultInstructions,
+		compiled:     true,
+		in:           in,
+		out:          out,
+		hooks:        append([]Hooks(nil), defaultHooks...),
+	}
+}
+
+// NewSyncMachine creates a new Machine that can be driven from a single goroutine. Inputs are given to it with
+// PushInput, and Run returns whenever the program needs an input that hasn't been given yet, produces an output, or
+// halts. Outputs are collected with Outputs
+func NewSyncMachine(codes []int) *Machine {
+	inputs, outputs := NewSliceInput(), &SliceOutput{}
+	m := NewMachine(codes, inputs, outputs)
+	m.inputs, m.outputs = inputs, outputs
+	return m
+}
+
+// Clone returns an independent copy of the machine in its current state. The copy shares memory pages with the
+// original until either of them writes to a page, so cloning a machine is cheap even if it has a lot of memory.
+// Buffered inputs and outputs, including those of a SliceInput or SliceOutput, are copied. Any other input or output
+// would have to be shared with the original, and the two machines would interfere with each other, so cloning a
+// machine that uses one fails with ErrCloneIO
+func (m *Machine) Clone() (*Machine, error) {
+	c := &Machine{
+		memory:       m.memory.Clone(),
+		ip:           m.ip,
+		relativeBase: m.relativeBase,
+		halted:       m.halted,
+		err:          m.err,
+		strict:       m.strict,
+		instructions: m.instructions,
+		compiled:     m.compiled,
+		hooks:        append([]Hooks(nil), m.hooks...),
+	}
+
+	switch in := m.in.(type) {
+	case nil:
+	case *SliceInput:
+		inputs := NewSliceInput(append([]int(nil), in.values...)...)
+		c.in = inputs
+		if m.inputs == in {
+			c.inputs = inputs
+		}
+	default:
+		return nil, fmt.Errorf("%w: input of type %T", ErrCloneIO, m.in)
+	}
+
+	switch out := m.out.(type) {
+	case nil:
+	case *SliceOutput:
+		outputs := &SliceOutput{Values: append([]int(nil), out.Values...)}
+		c.out = outputs
+		if m.outputs == out {
+			c.outputs = outputs
+		}
+	default:
+		return nil, fmt.Errorf("%w: output of type %T", ErrCloneIO, m.out)
+	}
+	return c, nil
+}
+
+// PushInput adds values to the end of the input buffer of a machine created by NewSyncMachine
+func (m *Machine) PushInput(values ...int) {
+	if m.inputs == nil {
+		panic("PushInput called on a machine without an input buffer")
+	}
+	m.inputs.Push(values...)
+}
+
+// Outputs removes and returns all the values in the output buffer of a machine created by NewSyncMachine
+func (m *Machine) Outputs() []int {
+	if m.outputs == nil {
+		panic("Outputs called on a machine without an output buffer")
+	}
+	outputs := m.outputs.Values
+	m.outputs.Values = nil
+	return outputs
+}
+
+// SetInput changes where the machine reads its inputs from. A machine created by NewSyncMachine no longer buffers its
+// inputs afterwards
+func (m *Machine) SetInput(in Input) {
+	m.in, m.inputs = in, nil
+}
+
+// SetOutput changes where the machine writes its outputs to. A machine created by NewSyncMachine no longer buffers its
+// outputs, or returns from Run after each output, afterwards
+func (m *Machine) SetOutput(out Output) {
+	m.out, m.outputs = out, nil
+}
+
+// SetStrict turns strict mode on or off. A strict machine fails with ErrOverflow if an add or multiply instruction
+// overflows, and with ErrJumpOutOfRange if a jump would leave the program that was loaded, rather than carrying on with
+// a corrupt value. Memory written beyond the end of the program doesn't count as part of it. Errors from a strict
+// machine record the operands of the faulting instruction. Negative addresses are always an error, strict or not
+func (m *Machine) SetStrict(strict bool) {
+	m.strict = strict
+}
+
+// SetInstructionSet changes the instructions the machine understands
+func (m *Machine) SetInstructionSet(s *InstructionSet) {
+	m.instructions = s
+	// Anything compiled so far was checked against the old instructions
+	m.memory.code = nil
+}
+
+// InstructionSet returns the instructions the machine understands
+func (m *Machine) InstructionSet() *InstructionSet {
+	return m.instructions
+}
+
+// Memory returns the machine's memory
+func (m *Machine) Memory() *Memory {
+	return m.memory
+}
+
+// IP returns the current instruction pointer
+func (m *Machine) IP() int {
+	return m.ip
+}
+
+// SetIP moves the instruction pointer to the given address
+func (m *Machine) SetIP(ip int) {
+	m.ip = ip
+}
+
+// RelativeBase returns the current relative base
+func (m *Machine) RelativeBase() int {
+	return m.relativeBase
+}
+
+// SetRelativeBase sets the relative base to the given value
+func (m *Machine) SetRelativeBase(relativeBase int) {
+	m.relativeBase = relativeBase
+}
+
+// Halted returns true iff the machine has stopped, either by executing a halt instruction or because of an error
+func (m *Machine) Halted() bool {
+	return m.halted
+}
+
+// Err returns the error that stopped the machine, if any
+func (m *Machine) Err() error {
+	return m.err
+}
+
+// Step executes the instruction at the instruction pointer
+func (m *Machine) Step() (Status, error) {
+	return m.StepContext(context.Background())
+}
+
+// StepContext executes the instruction at the instruction pointer. If the instruction needs an input and the input
+// has none available, the instruction pointer is left where it is and StatusNeedsInput is returned. If the context is
+// cancelled while the instruction is waiting for input or output, the machine stops and the context's error is
+// returned
+func (m *Machine) StepContext(ctx context.Context) (Status, error) {
+	if m.halted {
+		return StatusHalted, ErrHalted
+	}
+
+	code, err := m.memory.Read(m.ip)
+	if err != nil {
+		return StatusHalted, m.fail(m.execError(code, err))
+	}
+	def, modes, err := m.instructions.decode(code)
+	if err != nil {
+		return StatusHalted, m.fail(m.execError(code, err))
+	}
+	m.modes = *modes
+
+	ip := m.ip
+	if m.hooks != nil {
+		m.hookRelativeBase = m.relativeBase
+		m.beforeInstruction(def)
+	} else if m.strict {
+		m.operands = [maxParameters]Operand{}
+	}
+
+	status, err := m.execute(ctx, def)
+	if err != nil {
+		err = m.execError(code, err)
+		if execErr, ok := err.(*ExecError); ok && m.strict {
+			execErr.Operands = append([]Operand{}, m.operands[:len(def.Operands)]...)
+		}
+		return StatusHalted, m.fail(err)
+	}
+
+	if m.hooks != nil && status != StatusNeedsInput {
+		m.afterInstruction(ip, def)
+	}
+	return status, nil
+}
+
+// Run executes instructions until the program halts, needs an input that isn't available, or an error occurs.
+// Machines created with NewSyncMachine also return after every output
+func (m *Machine) Run() (Status, error) {
+	return m.RunContext(context.Background())
+}
+
+// RunContext executes instructions in the same way as Run, but also stops when the context is cancelled. Cancelling
+// the context stops the machine even if it is blocked waiting for input or output
+func (m *Machine) RunContext(ctx context.Context) (Status, error) {
+	return m.run(ctx, m.outputs != nil)
+}
+
+// RunUntilInput executes instructions until the program halts, needs an input that isn't available, or an error
+// occurs, without stopping for outputs
+func (m *Machine) RunUntilInput() (Status, error) {
+	return m.run(context.Background(), false)
+}
+
+// run executes instructions until the machine can't continue, or optionally until there is an output
+func (m *Machine) run(ctx context.Context, yieldOutput bool) (Status, error) {
+	done := ctx.Done()
+	code := m.compiledCode()
+	for {
+		if done != nil && !m.halted {
+			select {
+			case <-done:
+				return StatusHalted, m.fail(ctx.Err())
+			default:
+			}
+		}
+
+		// Run compiled instructions for as long as possible, then interpret the one they stopped at
+		if code != nil && !m.halted && !code.run(m, done) {
+			return StatusHalted, m.fail(ctx.Err())
+		}
+
+		status, err := m.StepContext(ctx)
+		if err != nil {
+			return status, err
+		}
+
+		switch status {
+		case StatusNeedsInput, StatusHalted:
+			return status, nil
+		case StatusHasOutput:
+			if yieldOutput {
+				return status, nil
+			}
+		}
+	}
+}
+
+// fail stops the machine with the given error
+func (m *Machine) fail(err error) error {
+	m.err = err
+	m.stop()
+	return err
+}
+
+// stop marks the machine as halted and closes the output so consumers know there's nothing more to come
+func (m *Machine) stop() {
+	m.halted = true
+	if closer, ok := m.out.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// operand decodes the n-th parameter of the current instruction, working out the address it refers to
+func (m *Machine) operand(n int) (*Operand, error) {
+	raw, err := m.memory.Read(m.ip + n + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &m.operands[n]
+	*op = Operand{
+		Mode: m.modes[n],
+		Raw:  raw,
+	}
+	if op.Mode != ModeImmediate {
+		if op.Addr, err = operandAddress(raw, op.Mode, m.relativeBase); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+// arg returns the value of the n-th parameter of the current instruction
+func (m *Machine) arg(n int) (int, error) {
+	op, err := m.operand(n)
+	if err != nil {
+		return 0, err
+	}
+
+	if op.Mode == ModeImmediate {
+		op.Value = op.Raw
+	} else if op.Value, err = m.read(op.Addr); err != nil {
+		return 0, err
+	}
+	op.Resolved = true
+	return op.Value, nil
+}
+
+// dst checks the n-th parameter of the current instruction can be written to, ready for a call to store
+func (m *Machine) dst(n int) error {
+	op, err := m.operand(n)
+	if err != nil {
+		return err
+	}
+	if op.Mode == ModeImmediate {
+		return ErrImmediateDestination
+	}
+	return nil
+}
+
+// store writes a value to the address the n-th parameter of the current instruction refers to
+func (m *Machine) store(n, val int) error {
+	op := &m.operands[n]
+	if err := m.write(op.Addr, val); err != nil {
+		return err
+	}
+	op.Value, op.Resolved = val, true
+	return nil
+}
+
+// read reads a value from memory for an instruction
+func (m *Machine) read(addr int) (int, error) {
+	val, err := m.memory.Read(addr)
+	if err == nil && m.hooks != nil {
+		m.memoryRead(addr, val)
+	}
+	return val, err
+}
+
+// write writes a value to memory for an instruction
+func (m *Machine) write(addr, val int) error {
+	err := m.memory.Write(addr, val)
+	if err == nil && m.hooks != nil {
+		m.memoryWrite(addr, val)
+	}
+	return err
+}
+
+// execute runs the handler of a single instruction, moving the instruction pointer on afterwards
+func (m *Machine) execute(ctx context.Context, def *InstructionDef) (Status, error) {
+	m.exec = Exec{
+		m:    m,
+		ctx:  ctx,
+		next: m.ip + len(def.Operands) + 1,
+	}
+
+	status, err := def.Handler(&m.exec)
+	if err != nil {
+		return status, err
+	}
+
+	switch status {
+	case StatusNeedsInput:
+		// Leave the instruction pointer here so the input is retried next time
+		return status, nil
+	case StatusHalted:
+		m.stop()
+		return status, nil
+	}
+
+	next := m.exec.next
+	if m.strict && m.exec.jumped && (next < 0 || next >= m.memory.loaded) {
+		return status, ErrJumpOutOfRange
+	}
+	if next < 0 {
+		// Blame the jump, rather than the instruction that isn't at the negative address
+		return status, fmt.Errorf("%w %d", ErrNegativeAddress, next)
+	}
+	m.ip = next
+	return status, nil
+}
+
+// minInt is the smallest value an int can hold
+const minInt = -1 << (strconv.IntSize - 1)
@@ -0,0 +1,198 @@
+package opcode
+
+import "fmt"
+
+// denseGrowLimit is how far past the end of the dense memory a write can be before it is stored sparsely instead
+const denseGrowLimit = 4096
+
+// pageShift is the log2 of the number of values in a page of dense memory
+const pageShift = 10
+
+const (
+	pageSize = 1 << pageShift
+	pageMask = pageSize - 1
+)
+
+// Memory is the memory of an Intcode machine. Addresses near the start of memory are stored in a slice, and addresses
+// far beyond the end of it are stored in a map, so a program can write anywhere without allocating everything in
+// between. Reading an address that has never been written returns 0
+//
+// The dense part is split into fixed size pages. A clone of a memory shares its pages with the original until either
+// of them writes to a page, when the writer takes its own copy, so cloning only costs as much as the pages that are
+// later changed
+type Memory struct {
+	pages  []page
+	length int
+	// loaded is the length of the program the memory was created with, which doesn't change as the memory grows
+	loaded int
+
+	sparse map[int]int
+	// sparseShared is true if the sparse map may be used by another memory
+	sparseShared bool
+
+	// code holds the instructions compiled from the memory, which are forgotten when the memory they came from is
+	// written
+	code *compiledCode
+}
+
+// page is a block of dense memory. Every page holds pageSize values, except that the last page may be shorter
+type page struct {
+	values []int
+	// shared is true if the page may be used by another memory, and must be copied before it is written
+	shared bool
+}
+
+// NewMemory creates a new Memory holding the given program. The memory takes ownership of `codes`
+func NewMemory(codes []int) *Memory {
+	mem := &Memory{
+		length: len(codes),
+		loaded: len(codes),
+		sparse: map[int]int{},
+	}
+	if len(codes) > 0 {
+		mem.pages = make([]page, 0, (len(codes)+pageMask)>>pageShift)
+	}
+	for start := 0; start < len(codes); start += pageSize {
+		end := start + pageSize
+		if end > len(codes) {
+			end = len(codes)
+		}
+		mem.pages = append(mem.pages, page{values: codes[start:end:end]})
+	}
+	return mem
+}
+
+// Clone returns a copy of the memory. The copy shares pages with the original until they are written to
+func (mem *Memory) Clone() *Memory {
+	for i := range mem.pages {
+		mem.pages[i].shared = true
+	}
+	mem.sparseShared = true
+
+	return &Memory{
+		pages:        append([]page(nil), mem.pages...),
+		length:       mem.length,
+		loaded:       mem.loaded,
+		sparse:       mem.sparse,
+		sparseShared: true,
+	}
+}
+
+// Read returns the value stored at the given address
+func (mem *Memory) Read(addr int) (int, error) {
+	if addr < 0 {
+		return 0, fmt.Errorf("%w %d", ErrNegativeAddress, addr)
+	}
+	if addr < mem.length {
+		return mem.pages[addr>>pageShift].values[addr&pageMask], nil
+	}
+	return mem.sparse[addr], nil
+}
+
+// readDense returns the value at an address in the dense memory, or false if the address isn't in it. It is small
+// enough to be inlined where reads need to be fast
+func (mem *Memory) readDense(addr int) (int, bool) {
+	if uint(addr) < uint(mem.length) {
+		return mem.pages[addr>>pageShift].values[addr&pageMask], true
+	}
+	return 0, false
+}
+
+// writeDense stores a value at an address in the dense memory, returning false without storing it if the address
+// isn't in the dense memory or its page is shared. It is small enough to be inlined where writes need to be fast
+func (mem *Memory) writeDense(addr, val int) bool {
+	if uint(addr) >= uint(mem.length) || mem.pages[addr>>pageShift].shared {
+		return false
+	}
+	if mem.code != nil {
+		mem.code.invalidate(addr)
+	}
+	mem.pages[addr>>pageShift].values[addr&pageMask] = val
+	return true
+}
+
+// Write stores a value at the given address, growing the memory if needed
+func (mem *Memory) Write(addr, val int) error {
+	if addr < 0 {
+		return fmt.Errorf("%w %d", ErrNegativeAddress, addr)
+	}
+	if mem.code != nil {
+		mem.code.invalidate(addr)
+	}
+	if addr < mem.length {
+		mem.writable(addr)[addr&pageMask] = val
+		return nil
+	}
+	if addr < mem.length+denseGrowLimit {
+		mem.grow(addr + 1)
+		mem.writable(addr)[addr&pageMask] = val
+		return nil
+	}
+
+	mem.ownSparse()
+	if val == 0 {
+		delete(mem.sparse, addr)
+	} else {
+		mem.sparse[addr] = val
+	}
+	return nil
+}
+
+// Len returns the length of the dense part of the memory
+func (mem *Memory) Len() int {
+	return mem.length
+}
+
+// Slice returns a copy of the dense part of the memory, starting from address 0. Values held sparsely are not included
+func (mem *Memory) Slice() []int {
+	values := make([]int, 0, mem.length)
+	for _, page := range mem.pages {
+		values = append(values, page.values...)
+	}
+	return values[:mem.length]
+}
+
+// writable returns the page holding a dense address so that it can be written to, copying it first if it is shared
+func (mem *Memory) writable(addr int) []int {
+	p := &mem.pages[addr>>pageShift]
+	if p.shared {
+		p.values = append(make([]int, 0, len(p.values)), p.values...)
+		p.shared = false
+	}
+	return p.values
+}
+
+// ownSparse copies the sparse map if it is shared, so that it can be written to
+func (mem *Memory) ownSparse() {
+	if !mem.sparseShared {
+		return
+	}
+	sparse := make(map[int]int, len(mem.sparse))
+	for addr, val := range mem.sparse {
+		sparse[addr] = val
+	}
+	mem.sparse, mem.sparseShared = sparse, false
+}
+
+// grow extends the dense memory to hold at least `n` addresses, moving in any values that were stored sparsely
+func (mem *Memory) grow(n int) {
+	// Pages are always zero beyond the end of the dense memory, so a short last page needs to be filled out, and then
+	// only new pages need to be added
+	if last := len(mem.pages) - 1; last >= 0 && len(mem.pages[last].values) < pageSize {
+		values := make([]int, pageSize)
+		copy(values, mem.pages[last].values)
+		mem.pages[last] = page{values: values}
+	}
+	for len(mem.pages)*pageSize < n {
+		mem.pages = append(mem.pages, page{values: make([]int, pageSize)})
+	}
+	mem.length = n
+
+	for addr, val := range mem.sparse {
+		if addr < n {
+			mem.ownSparse()
+			mem.writable(addr)[addr&pageMask] = val
+			delete(mem.sparse, addr)
+		}
+	}
+}
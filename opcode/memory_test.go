@@ -0,0 +1,123 @@
+package opcode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestMemory(t *testing.T) {
+	cases := map[string]struct {
+		codes    []int
+		addr     int
+		val      int
+		expected []int
+	}{
+		"Inside Program": {
+			codes:    []int{1, 2, 3},
+			addr:     1,
+			val:      7,
+			expected: []int{1, 7, 3},
+		},
+		"Just Past The End": {
+			codes:    []int{1, 2, 3},
+			addr:     5,
+			val:      7,
+			expected: []int{1, 2, 3, 0, 0, 7},
+		},
+		"Far Past The End": {
+			codes:    []int{1, 2, 3},
+			addr:     1 << 40,
+			val:      7,
+			expected: []int{1, 2, 3},
+		},
+	}
+
+	for name, data := range cases {
+		mem := opcode.NewMemory(data.codes)
+		require.NoErrorf(t, mem.Write(data.addr, data.val), "Case %s", name)
+
+		val, err := mem.Read(data.addr)
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, data.val, val, "Case %s", name)
+		require.Equalf(t, data.expected, mem.Slice(), "Case %s", name)
+
+		// Untouched addresses read as 0
+		val, err = mem.Read(1 << 41)
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, 0, val, "Case %s", name)
+	}
+}
+
+func TestMemoryGrowsOverSparseValues(t *testing.T) {
+	mem := opcode.NewMemory([]int{1})
+	require.NoError(t, mem.Write(5000, 42))
+	require.Equal(t, 1, mem.Len())
+
+	// Growing the dense memory past 5000 must keep the sparse value
+	require.NoError(t, mem.Write(4000, 1))
+	require.NoError(t, mem.Write(5500, 1))
+	val, err := mem.Read(5000)
+	require.NoError(t, err)
+	require.Equal(t, 42, val)
+	require.Equal(t, 42, mem.Slice()[5000])
+}
+
+func TestMemoryNegativeAddress(t *testing.T) {
+	mem := opcode.NewMemory([]int{1})
+
+	_, err := mem.Read(-1)
+	require.Error(t, err)
+	require.Error(t, mem.Write(-1, 0))
+}
+
+func TestMemoryClone(t *testing.T) {
+	codes := make([]int, 3000)
+	for i := range codes {
+		codes[i] = i
+	}
+	mem := opcode.NewMemory(codes)
+	require.NoError(t, mem.Write(1<<40, 1))
+
+	clone := mem.Clone()
+	writes := []struct {
+		mem  *opcode.Memory
+		addr int
+		val  int
+	}{
+		{mem, 10, -1},
+		{clone, 2500, -2},
+		{clone, 3100, -3},
+		{mem, 1 << 40, 0},
+		{clone, 1 << 41, -4},
+	}
+	for _, w := range writes {
+		require.NoError(t, w.mem.Write(w.addr, w.val))
+	}
+
+	// Each write is only seen by the memory it was made to
+	expected := []struct {
+		addr  int
+		mem   int
+		clone int
+	}{
+		{10, -1, 10},
+		{2500, 2500, -2},
+		{3100, 0, -3},
+		{1 << 40, 0, 1},
+		{1 << 41, 0, -4},
+	}
+	for _, e := range expected {
+		val, err := mem.Read(e.addr)
+		require.NoError(t, err)
+		require.Equalf(t, e.mem, val, "Original at %d", e.addr)
+
+		val, err = clone.Read(e.addr)
+		require.NoError(t, err)
+		require.Equalf(t, e.clone, val, "Clone at %d", e.addr)
+	}
+	require.Equal(t, 3000, mem.Len())
+	require.Equal(t, 3101, clone.Len())
+}
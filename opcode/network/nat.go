@@ -0,0 +1,34 @@
+package network
+
+// NATAddress is the address the NAT is usually attached at
+const NATAddress = 255
+
+// NAT is a node that keeps the last packet sent to it, and sends it on to address 0 whenever the network is idle. It
+// stops the network when it would send address 0 the same Y value twice in a row
+type NAT struct {
+	// Last is the last packet the NAT received
+	Last *Packet
+	// Sent are the packets the NAT has sent to address 0, in order
+	Sent []Packet
+}
+
+// Receive keeps the packet, replacing any previous one
+func (nat *NAT) Receive(n *Network, p Packet) error {
+	nat.Last = &p
+	return nil
+}
+
+// Idle sends the last packet the NAT received to address 0
+func (nat *NAT) Idle(n *Network) error {
+	if nat.Last == nil {
+		return nil
+	}
+
+	p := Packet{Src: NATAddress, Dest: 0, X: nat.Last.X, Y: nat.Last.Y}
+	repeated := len(nat.Sent) > 0 && nat.Sent[len(nat.Sent)-1].Y == p.Y
+	nat.Sent = append(nat.Sent, p)
+	if repeated {
+		return ErrStop
+	}
+	return n.Send(p)
+}
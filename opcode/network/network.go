@@ -0,0 +1,233 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"aoc/opcode"
+)
+
+var (
+	// ErrStop is returned by a node to stop the network. Run returns nil when it is stopped this way
+	ErrStop = errors.New("Stop the network")
+	// ErrIdle is returned by Run when the network is idle and nothing is going to wake it up
+	ErrIdle = errors.New("Network is idle")
+)
+
+// defaultIdleTurns is how many turns in a row a machine must spend waiting for packets before it counts as idle
+const defaultIdleTurns = 2
+
+// Packet is a message sent between addresses on the network
+type Packet struct {
+	// Src is the address that sent the packet
+	Src int
+	// Dest is the address the packet is sent to
+	Dest int
+	X, Y int
+}
+
+// Node is something on the network other than a machine, such as a NAT or a monitor
+type Node interface {
+	// Receive is called with every packet sent to the node. Returning ErrStop stops the network
+	Receive(n *Network, p Packet) error
+}
+
+// IdleNode is a Node that wants to know when the network is idle, for example to wake it up again
+type IdleNode interface {
+	Node
+	// Idle is called when every machine is waiting for packets and none are being sent. Returning ErrStop stops the
+	// network
+	Idle(n *Network) error
+}
+
+// NodeFunc is a Node that calls a function with every packet it receives
+type NodeFunc func(n *Network, p Packet) error
+
+// Receive calls the function
+func (f NodeFunc) Receive(n *Network, p Packet) error {
+	return f(n, p)
+}
+
+// Network runs a group of machines that send each other packets. Each machine is given its address as its first
+// input, and then reads packets as pairs of X and Y values, reading -1 when there are no packets waiting for it. It
+// sends a packet by outputting the destination address, X and Y
+//
+// The machines take turns on a single goroutine, so a network always runs the same way for the same programs
+type Network struct {
+	machines []*opcode.Machine
+	// queues holds the values waiting to be read by each machine
+	queues [][]int
+	// pending holds the values each machine has output towards its next packet
+	pending [][]int
+	// idle counts how many turns in a row each machine has waited for packets without sending any
+	idle  []int
+	nodes map[int]Node
+
+	// IdleTurns is how many turns in a row every machine must spend waiting for packets, without sending any, before
+	// the network is idle
+	IdleTurns int
+}
+
+// New creates a network of `size` machines running the given program, with addresses from 0 to size-1
+func New(codes []int, size int) *Network {
+	n := &Network{
+		machines:  make([]*opcode.Machine, size),
+		queues:    make([][]int, size),
+		pending:   make([][]int, size),
+		idle:      make([]int, size),
+		nodes:     map[int]Node{},
+		IdleTurns: defaultIdleTurns,
+	}
+	for addr := range n.machines {
+		n.machines[addr] = opcode.NewSyncMachine(append(codes[:0:0], codes...))
+		n.queues[addr] = []int{addr}
+	}
+	return n
+}
+
+// Machine returns the machine at the given address
+func (n *Network) Machine(addr int) *opcode.Machine {
+	return n.machines[addr]
+}
+
+// Attach adds a node to the network at an address that isn't used by a machine
+func (n *Network) Attach(addr int, node Node) error {
+	if n.isMachine(addr) {
+		return fmt.Errorf("Address %d is already used by a machine", addr)
+	}
+	if _, ok := n.nodes[addr]; ok {
+		return fmt.Errorf("Address %d already has a node attached", addr)
+	}
+	n.nodes[addr] = node
+	return nil
+}
+
+// Send sends a packet to its destination
+func (n *Network) Send(p Packet) error {
+	if n.isMachine(p.Dest) {
+		n.queues[p.Dest] = append(n.queues[p.Dest], p.X, p.Y)
+		return nil
+	}
+	if node, ok := n.nodes[p.Dest]; ok {
+		return node.Receive(n, p)
+	}
+	return fmt.Errorf("Packet %+v sent to unknown address %d", p, p.Dest)
+}
+
+// Run runs the network until a node stops it, every machine halts, or an error occurs. If the network becomes idle
+// and none of its nodes send a packet to wake it up, Run fails with ErrIdle
+func (n *Network) Run() error {
+	err := n.run()
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+// run gives every machine a turn until something stops the network
+func (n *Network) run() error {
+	for {
+		running := false
+		for addr, m := range n.machines {
+			if m.Halted() {
+				continue
+			}
+			if err := n.turn(addr); err != nil {
+				return err
+			}
+			running = running || !m.Halted()
+		}
+		if !running {
+			return nil
+		}
+
+		if n.isIdle() {
+			if err := n.wake(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// turn gives a machine everything in its queue, or -1 if it is empty, and runs it until it needs more input, sending
+// any packets it outputs
+func (n *Network) turn(addr int) error {
+	m := n.machines[addr]
+	if len(n.queues[addr]) == 0 {
+		m.PushInput(-1)
+		n.idle[addr]++
+	} else {
+		m.PushInput(n.queues[addr]...)
+		n.queues[addr] = nil
+		n.idle[addr] = 0
+	}
+
+	if _, err := m.RunUntilInput(); err != nil {
+		return fmt.Errorf("Machine %d: %w", addr, err)
+	}
+
+	outputs := m.Outputs()
+	if len(outputs) > 0 {
+		n.idle[addr] = 0
+	}
+	n.pending[addr] = append(n.pending[addr], outputs...)
+	for len(n.pending[addr]) >= 3 {
+		values := n.pending[addr]
+		n.pending[addr] = values[3:]
+		if err := n.Send(Packet{Src: addr, Dest: values[0], X: values[1], Y: values[2]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIdle returns true iff every running machine has been waiting for packets for long enough, with none on the way
+func (n *Network) isIdle() bool {
+	for addr, m := range n.machines {
+		if m.Halted() {
+			continue
+		}
+		if len(n.queues[addr]) > 0 || n.idle[addr] < n.IdleTurns {
+			return false
+		}
+	}
+	return true
+}
+
+// wake tells the nodes the network is idle, failing with ErrIdle if none of them send a packet to a machine
+func (n *Network) wake() error {
+	for addr := range n.machines {
+		n.idle[addr] = 0
+	}
+
+	for _, addr := range n.nodeAddrs() {
+		if node, ok := n.nodes[addr].(IdleNode); ok {
+			if err := node.Idle(n); err != nil {
+				return err
+			}
+		}
+	}
+
+	for addr, queue := range n.queues {
+		if len(queue) > 0 && !n.machines[addr].Halted() {
+			return nil
+		}
+	}
+	return ErrIdle
+}
+
+// nodeAddrs returns the addresses of the nodes in ascending order, so they are always called in the same order
+func (n *Network) nodeAddrs() []int {
+	addrs := make([]int, 0, len(n.nodes))
+	for addr := range n.nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	return addrs
+}
+
+// isMachine returns true iff the address belongs to a machine
+func (n *Network) isMachine(addr int) bool {
+	return addr >= 0 && addr < len(n.machines)
+}
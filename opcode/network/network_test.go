@@ -0,0 +1,121 @@
+package network_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+	"aoc/opcode/network"
+)
+
+// relay returns a program for a network of `size` machines. Each machine passes on every packet it receives to the
+// next address with X increased by 1, and the last machine sends them to the NAT. Machine 0 starts things off by
+// sending X=10, Y=20
+func relay(size int) []int {
+	return opcode.MustAssemble(fmt.Sprintf(`
+		        in [addr]
+		        add [addr], #1, [dest]
+		        eq [dest], #%d, [t]
+		        jf [t], #start
+		        add #255, #0, [dest]
+		start:  jt [addr], #loop
+		        out [dest]
+		        out #10
+		        out #20
+		loop:   in [x]
+		        eq [x], #-1, [t]
+		        jt [t], #loop
+		        in [y]
+		        add [x], #1, [x]
+		        out [dest]
+		        out [x]
+		        out [y]
+		        jt #1, #loop
+		addr:   data 0
+		dest:   data 0
+		t:      data 0
+		x:      data 0
+		y:      data 0
+	`, size))
+}
+
+func TestNetworkRouting(t *testing.T) {
+	n := network.New(relay(3), 3)
+
+	packets := []network.Packet{}
+	err := n.Attach(network.NATAddress, network.NodeFunc(func(n *network.Network, p network.Packet) error {
+		packets = append(packets, p)
+		return network.ErrStop
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, n.Run())
+	require.Equal(t, []network.Packet{{Src: 2, Dest: 255, X: 12, Y: 20}}, packets)
+}
+
+func TestNetworkNAT(t *testing.T) {
+	n := network.New(relay(1), 1)
+	nat := &network.NAT{}
+	require.NoError(t, n.Attach(network.NATAddress, nat))
+
+	// Every time the network goes idle, the NAT wakes up machine 0, until it sends the same Y twice in a row
+	require.NoError(t, n.Run())
+	require.Equal(t, []network.Packet{
+		{Src: 255, Dest: 0, X: 10, Y: 20},
+		{Src: 255, Dest: 0, X: 11, Y: 20},
+	}, nat.Sent)
+	require.Equal(t, &network.Packet{Src: 0, Dest: 255, X: 11, Y: 20}, nat.Last)
+}
+
+func TestNetworkErrors(t *testing.T) {
+	cases := map[string]struct {
+		codes    []int
+		size     int
+		expected string
+	}{
+		"Idle": {
+			// Waits for packets forever
+			codes:    opcode.MustAssemble("in [0]\nloop: in [0]\njt #1, #loop"),
+			size:     2,
+			expected: network.ErrIdle.Error(),
+		},
+		"Unknown Address": {
+			codes:    opcode.MustAssemble("in [0]\nout #7\nout #1\nout #2\nhlt"),
+			size:     2,
+			expected: "Packet {Src:0 Dest:7 X:1 Y:2} sent to unknown address 7",
+		},
+		"Machine Error": {
+			codes:    []int{3, 0, 42},
+			size:     1,
+			expected: "Machine 0: Error executing op42",
+		},
+	}
+
+	for name, data := range cases {
+		err := network.New(data.codes, data.size).Run()
+		require.Errorf(t, err, "Case %s", name)
+		require.Containsf(t, err.Error(), data.expected, "Case %s", name)
+	}
+
+	// Machine errors can still be inspected
+	err := network.New([]int{3, 0, 42}, 1).Run()
+	require.True(t, errors.Is(err, opcode.ErrUnknownInstruction))
+}
+
+func TestNetworkHalts(t *testing.T) {
+	n := network.New(opcode.MustAssemble("in [0]\nhlt"), 3)
+	require.NoError(t, n.Run())
+	for addr := 0; addr < 3; addr++ {
+		require.True(t, n.Machine(addr).Halted())
+	}
+}
+
+func TestNetworkAttach(t *testing.T) {
+	n := network.New([]int{99}, 2)
+	require.EqualError(t, n.Attach(1, &network.NAT{}), "Address 1 is already used by a machine")
+	require.NoError(t, n.Attach(255, &network.NAT{}))
+	require.EqualError(t, n.Attach(255, &network.NAT{}), "Address 255 already has a node attached")
+}
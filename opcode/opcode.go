@@ -1,243 +1,86 @@
 package opcode
 
 import (
+	"context"
 	"fmt"
 	"strconv"
-
-	"aoc/utils"
+	"strings"
 )
 
-// DetermineCodeInfo gets the code and all the paramter modes for that node
-func DetermineCodeInfo(n int) (code Instruction, paramModes []Mode, err error) {
-	digits := utils.GetDigits(n)
-
-	// Get the code
-	var intcode int
-	if len(digits) == 1 {
-		intcode = digits[0]
-	} else {
-		intcode, err = strconv.Atoi(fmt.Sprintf("%d%d", digits[len(digits)-2], digits[len(digits)-1]))
+// Parse parses a comma-separated Intcode program
+func Parse(program string) ([]int, error) {
+	codeStrings := strings.Split(strings.TrimSpace(program), ",")
+	codes := make([]int, len(codeStrings))
+	for i, c := range codeStrings {
+		x, err := strconv.Atoi(strings.TrimSpace(c))
 		if err != nil {
-			return 0, nil, err
+			return nil, err
 		}
+		codes[i] = x
 	}
-	code = Instruction(intcode)
+	return codes, nil
+}
 
-	paramModes = make([]Mode, InstructionParameterCount[code])
-	for i := 0; i < len(paramModes); i++ {
-		j := len(digits) - 3 - i
-		if j >= 0 {
-			paramModes[i] = Mode(digits[j])
-		} else {
-			paramModes[i] = ModePosition
-		}
+// DetermineCodeInfo gets the code and all the paramter modes for that node
+func DetermineCodeInfo(n int) (code Instruction, paramModes []Mode, err error) {
+	def, modes, err := DefaultInstructions.decode(n)
+	if err != nil {
+		return Instruction(n % 100), nil, err
 	}
 
-	return code, paramModes, nil
+	paramModes = make([]Mode, len(def.Operands))
+	copy(paramModes, modes[:])
+	return def.Instruction, paramModes, nil
 }
 
 // GetArgumentValue returns the argument value for the current pointer
-func GetArgumentValue(ptr int, codes []int, mode Mode, relativeBase int) (int, error) {
-	switch mode {
-	case ModePosition:
-		return codes[codes[ptr]], nil
-	case ModeImmediate:
-		return codes[ptr], nil
-	case ModeRelative:
-		return codes[codes[ptr]+relativeBase], nil
-	default:
-		return 0, fmt.Errorf("Invalid mode %d", mode)
+func GetArgumentValue(ptr int, mem *Memory, mode Mode, relativeBase int) (int, error) {
+	param, err := mem.Read(ptr)
+	if err != nil {
+		return 0, err
+	}
+	if mode == ModeImmediate {
+		return param, nil
 	}
+
+	addr, err := operandAddress(param, mode, relativeBase)
+	if err != nil {
+		return 0, err
+	}
+	return mem.Read(addr)
 }
 
 // GetDestinationLocation returns a destination address in which to store a value
-func GetDestinationLocation(ptr int, codes []int, mode Mode, relativeBase int) (int, error) {
-	switch mode {
-	case ModePosition:
-		return codes[ptr], nil
-	case ModeRelative:
-		return codes[ptr] + relativeBase, nil
-	default:
-		return 0, fmt.Errorf("Invalid mode %d", mode)
+func GetDestinationLocation(ptr int, mem *Memory, mode Mode, relativeBase int) (int, error) {
+	param, err := mem.Read(ptr)
+	if err != nil {
+		return 0, err
 	}
+	return operandAddress(param, mode, relativeBase)
 }
 
-// ProcessInstruction processes a single instruction
-func ProcessInstruction(instructionPointer, relativeBase *int, codes []int, instruction Instruction, modes []Mode, in, out chan int, done chan bool) error {
-
-	// When the instruction has been processed, send `true` to the `done` chan so the caller knows we're done
-	defer func() {
-		*instructionPointer += (InstructionParameterCount[instruction] + 1)
-		done <- true
-	}()
-
-	switch instruction {
-	case InstructionAdd:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		dst, err := GetDestinationLocation(*instructionPointer+3, codes, modes[2], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		codes[dst] = arg1 + arg2
-		return nil
-
-	case InstructionMultiply:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		dst, err := GetDestinationLocation(*instructionPointer+3, codes, modes[2], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		codes[dst] = arg1 * arg2
-		return nil
-
-	case InstructionInput:
-		dst, err := GetDestinationLocation(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		codes[dst] = <-in
-		return nil
-
-	case InstructionOutput:
-		arg, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		out <- arg
-		return nil
-	case InstructionJumpTrue:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		if arg1 != 0 {
-			arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-			if err != nil {
-				return err
-			}
-
-			*instructionPointer = arg2
-			*instructionPointer -= (InstructionParameterCount[instruction] + 1) // because we automatically add this as part of the defer
-		}
-		return nil
-	case InstructionJumpFalse:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		if arg1 == 0 {
-			arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-			if err != nil {
-				return err
-			}
-
-			*instructionPointer = arg2
-			*instructionPointer -= (InstructionParameterCount[instruction] + 1) // because we automatically add this as part of the defer
-		}
-		return nil
-	case InstructionLessThan:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		dst, err := GetDestinationLocation(*instructionPointer+3, codes, modes[2], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		if arg1 < arg2 {
-			codes[dst] = 1
-		} else {
-			codes[dst] = 0
-		}
-		return nil
-	case InstructionEquals:
-		arg1, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-		arg2, err := GetArgumentValue(*instructionPointer+2, codes, modes[1], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		dst, err := GetDestinationLocation(*instructionPointer+3, codes, modes[2], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		if arg1 == arg2 {
-			codes[dst] = 1
-			// codes[codes[*instructionPointer+3]] = 1
-		} else {
-			codes[dst] = 0
-			// codes[codes[*instructionPointer+3]] = 0
-		}
-		return nil
-	case InstructionRelativeBaseOffset:
-		arg, err := GetArgumentValue(*instructionPointer+1, codes, modes[0], *relativeBase)
-		if err != nil {
-			return err
-		}
-
-		*relativeBase += arg
-		return nil
-	case InstructionHalt:
-		close(out)
-		return nil
+// operandAddress returns the address a parameter refers to. Immediate mode parameters don't refer to an address
+func operandAddress(param int, mode Mode, relativeBase int) (int, error) {
+	switch mode {
+	case ModePosition:
+		return param, nil
+	case ModeRelative:
+		return param + relativeBase, nil
+	case ModeImmediate:
+		return 0, ErrImmediateDestination
 	default:
-		return fmt.Errorf("Unknown instruction %d", instruction)
+		return 0, fmt.Errorf("%w %d", ErrInvalidMode, mode)
 	}
 }
 
 // Run runs an opcode program
 func Run(codes []int, in, out chan int) error {
-	ip, relativeBase := 0, 0
-
-	for {
-		done := make(chan bool, 1)
-		instruction, modes, err := DetermineCodeInfo(codes[ip])
-		if err != nil {
-			close(out)
-			return err
-		}
-
-		if instruction == InstructionHalt {
-			close(out)
-			return nil
-		}
-
-		err = ProcessInstruction(&ip, &relativeBase, codes, instruction, modes, in, out, done)
-		// Check for any error
-		if err != nil {
-			close(out)
-			return err
-		}
+	_, err := NewMachine(codes, ChanInput(in), ChanOutput(out)).Run()
+	return err
+}
 
-		// Block until we're done
-		<-done
-	}
+// RunContext runs an opcode program until it halts or the context is cancelled
+func RunContext(ctx context.Context, codes []int, in, out chan int) error {
+	_, err := NewMachine(codes, ChanInput(in), ChanOutput(out)).RunContext(ctx)
+	return err
 }
@@ -1,11 +1,19 @@
 package opcode_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"aoc/opcode"
+	"aoc/utils"
 )
 
 func TestDetermineCodeInfo(t *testing.T) {
@@ -74,6 +82,13 @@ func TestDetermineCodeInfo(t *testing.T) {
 	}
 }
 
+func TestDetermineCodeInfoUnknownInstruction(t *testing.T) {
+	for _, n := range []int{0, 10, 98, 1142, -1} {
+		_, _, err := opcode.DetermineCodeInfo(n)
+		require.Errorf(t, err, "Value %d", n)
+	}
+}
+
 func TestGetArgumentValue(t *testing.T) {
 	cases := map[string]struct {
 		ptr          int
@@ -106,18 +121,16 @@ func TestGetArgumentValue(t *testing.T) {
 	}
 
 	for name, data := range cases {
-		val, err := opcode.GetArgumentValue(data.ptr, data.codes, data.mode, data.relativeBase)
+		val, err := opcode.GetArgumentValue(data.ptr, opcode.NewMemory(data.codes), data.mode, data.relativeBase)
 		require.NoErrorf(t, err, "Case %s", name)
 		require.Equalf(t, data.expectedVal, val, "Case %s", name)
 	}
 }
 
-func TestProcessInstruction(t *testing.T) {
+func TestMachineStep(t *testing.T) {
 	cases := map[string]struct {
 		ptr                  int
 		codes                []int
-		instruction          opcode.Instruction
-		modes                []opcode.Mode
 		input                int
 		relativeBase         int
 		expectedCodes        []int
@@ -128,8 +141,6 @@ func TestProcessInstruction(t *testing.T) {
 		"Example 1": {
 			ptr:                  0,
 			codes:                []int{1002, 4, 3, 4, 33},
-			instruction:          opcode.InstructionMultiply,
-			modes:                []opcode.Mode{opcode.ModePosition, opcode.ModeImmediate, opcode.ModePosition},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{1002, 4, 3, 4, 99},
@@ -140,8 +151,6 @@ func TestProcessInstruction(t *testing.T) {
 		"Output": {
 			ptr:                  2,
 			codes:                []int{3, 0, 4, 0, 99},
-			instruction:          opcode.InstructionOutput,
-			modes:                []opcode.Mode{opcode.ModePosition},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{3, 0, 4, 0, 99},
@@ -152,8 +161,6 @@ func TestProcessInstruction(t *testing.T) {
 		"Jump True": {
 			ptr:                  0,
 			codes:                []int{1105, 1, 10, 99},
-			instruction:          opcode.InstructionJumpTrue,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{1105, 1, 10, 99},
@@ -164,8 +171,6 @@ func TestProcessInstruction(t *testing.T) {
 		"Jump False": {
 			ptr:                  0,
 			codes:                []int{1106, 0, 10, 99},
-			instruction:          opcode.InstructionJumpFalse,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{1106, 0, 10, 99},
@@ -175,9 +180,7 @@ func TestProcessInstruction(t *testing.T) {
 		},
 		"Less Than": {
 			ptr:                  0,
-			codes:                []int{11107, 1, 2, 0},
-			instruction:          opcode.InstructionLessThan,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModeImmediate},
+			codes:                []int{1107, 1, 2, 0},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{1, 1, 2, 0},
@@ -187,9 +190,7 @@ func TestProcessInstruction(t *testing.T) {
 		},
 		"Not Less Than": {
 			ptr:                  0,
-			codes:                []int{11107, 2, 1, 0},
-			instruction:          opcode.InstructionLessThan,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModeImmediate},
+			codes:                []int{1107, 2, 1, 0},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{0, 2, 1, 0},
@@ -199,9 +200,7 @@ func TestProcessInstruction(t *testing.T) {
 		},
 		"Equals": {
 			ptr:                  0,
-			codes:                []int{11108, 1, 1, 0},
-			instruction:          opcode.InstructionEquals,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModeImmediate},
+			codes:                []int{1108, 1, 1, 0},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{1, 1, 1, 0},
@@ -211,9 +210,7 @@ func TestProcessInstruction(t *testing.T) {
 		},
 		"Not Equals": {
 			ptr:                  0,
-			codes:                []int{11108, 1, 2, 0},
-			instruction:          opcode.InstructionEquals,
-			modes:                []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModeImmediate},
+			codes:                []int{1108, 1, 2, 0},
 			input:                1,
 			relativeBase:         0,
 			expectedCodes:        []int{0, 1, 2, 0},
@@ -224,8 +221,6 @@ func TestProcessInstruction(t *testing.T) {
 		"Relative Base Offset": {
 			ptr:                  0,
 			codes:                []int{109, 19},
-			instruction:          opcode.InstructionRelativeBaseOffset,
-			modes:                []opcode.Mode{opcode.ModeImmediate},
 			input:                1,
 			relativeBase:         2000,
 			expectedCodes:        []int{109, 19},
@@ -236,27 +231,25 @@ func TestProcessInstruction(t *testing.T) {
 	}
 
 	for name, data := range cases {
-		in := make(chan int)
+		in := make(chan int, 1)
+		in <- data.input
 		out := make(chan int, 20)
-		done := make(chan bool, 1)
 
-		err := opcode.ProcessInstruction(&data.ptr, &data.relativeBase, data.codes, data.instruction, data.modes, in, out, done)
+		m := opcode.NewMachine(data.codes, opcode.ChanInput(in), opcode.ChanOutput(out))
+		m.SetIP(data.ptr)
+		m.SetRelativeBase(data.relativeBase)
+
+		_, err := m.Step()
 		require.NoErrorf(t, err, "Case %s", name)
 
 		outputs := []int{}
-		isDone := false
-		for !isDone {
-			select {
-			case isDone = <-done:
-				continue
-			case output := <-out:
-				outputs = append(outputs, output)
-			}
+		for len(out) > 0 {
+			outputs = append(outputs, <-out)
 		}
 
-		require.Equalf(t, data.expectedCodes, data.codes, "Case %s", name)
-		require.Equalf(t, data.expectedNewPtr, data.ptr, "Case %s", name)
-		require.Equalf(t, data.expectedRelativeBase, data.relativeBase, "Case %s", name)
+		require.Equalf(t, data.expectedCodes, m.Memory().Slice(), "Case %s", name)
+		require.Equalf(t, data.expectedNewPtr, m.IP(), "Case %s", name)
+		require.Equalf(t, data.expectedRelativeBase, m.RelativeBase(), "Case %s", name)
 		require.Equalf(t, data.expectedOutputs, outputs, "Case %s", name)
 	}
 }
@@ -362,7 +355,7 @@ func TestRun(t *testing.T) {
 
 		out := make(chan int, 20)
 
-		err := opcode.Run(data.codes, in, out)
+		err := opcode.Run(append(data.codes[:0:0], data.codes...), in, out)
 		require.NoErrorf(t, err, "Case %s", name)
 
 		outputs := []int{}
@@ -370,5 +363,352 @@ func TestRun(t *testing.T) {
 			outputs = append(outputs, output)
 		}
 		require.Equalf(t, data.expected, outputs, "Case %s", name)
+
+		// Interpreting every instruction, rather than compiling them, must give the same results
+		interpreted := opcode.NewSyncMachine(append(data.codes[:0:0], data.codes...))
+		interpreted.SetCompiled(false)
+		interpreted.PushInput(data.input)
+		status, err := interpreted.RunUntilInput()
+		require.NoErrorf(t, err, "Case %s (interpreted)", name)
+		require.Equalf(t, opcode.StatusHalted, status, "Case %s (interpreted)", name)
+		require.Equalf(t, data.expected, interpreted.Outputs(), "Case %s (interpreted)", name)
+
+		// Arbitrary-precision arithmetic must give the same results
+		m := opcode.NewBigMachine(data.codes)
+		m.PushInput(big.NewInt(int64(data.input)))
+		status, err = m.RunUntilInput()
+		require.NoErrorf(t, err, "Case %s (big)", name)
+		require.Equalf(t, opcode.StatusHalted, status, "Case %s (big)", name)
+
+		outputs = []int{}
+		for _, output := range m.Outputs() {
+			outputs = append(outputs, int(output.Int64()))
+		}
+		require.Equalf(t, data.expected, outputs, "Case %s (big)", name)
+	}
+}
+
+func TestMachineHalts(t *testing.T) {
+	out := make(chan int, 20)
+	m := opcode.NewMachine([]int{104, 7, 99}, nil, opcode.ChanOutput(out))
+
+	status, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+	require.True(t, m.Halted())
+	require.NoError(t, m.Err())
+	require.Equal(t, 2, m.IP())
+	require.Equal(t, 7, <-out)
+
+	_, open := <-out
+	require.False(t, open)
+	_, err = m.Step()
+	require.Equal(t, opcode.ErrHalted, err)
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	cases := map[string]struct {
+		codes []int
+		in    chan int
+		out   chan int
+	}{
+		"Blocked On Input": {
+			codes: []int{3, 0, 99},
+			in:    make(chan int),
+			out:   make(chan int),
+		},
+		"Blocked On Output": {
+			codes: []int{104, 1, 99},
+			in:    make(chan int),
+			out:   make(chan int),
+		},
+		"Infinite Loop": {
+			codes: []int{1105, 1, 0},
+			in:    make(chan int),
+			out:   make(chan int),
+		},
+	}
+
+	for name, data := range cases {
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func(codes []int, in, out chan int) {
+			errs <- opcode.RunContext(ctx, codes, in, out)
+		}(data.codes, data.in, data.out)
+
+		cancel()
+		select {
+		case err := <-errs:
+			require.Equalf(t, context.Canceled, err, "Case %s", name)
+		case <-time.After(time.Second):
+			t.Fatalf("Case %s: machine did not stop after cancellation", name)
+		}
+
+		_, open := <-data.out
+		require.Falsef(t, open, "Case %s", name)
+	}
+}
+
+func TestSyncMachine(t *testing.T) {
+	// Repeatedly reads a value and outputs double it, until it reads 0
+	codes := opcode.MustAssemble(`
+		loop: in [100]
+		      jf [100], #end
+		      mul [100], #2, [101]
+		      out [101]
+		      jt #1, #loop
+		end:  hlt
+	`)
+
+	m := opcode.NewSyncMachine(codes)
+
+	status, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Equal(t, 0, m.IP())
+
+	m.PushInput(21)
+	status, err = m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHasOutput, status)
+	require.Equal(t, []int{42}, m.Outputs())
+
+	status, err = m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Empty(t, m.Outputs())
+
+	m.PushInput(1, 2, 3)
+	status, err = m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusNeedsInput, status)
+	require.Equal(t, []int{2, 4, 6}, m.Outputs())
+
+	m.PushInput(0)
+	status, err = m.Run()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+	require.True(t, m.Halted())
+}
+
+// countdown returns a program that decrements a counter from n to 0, then halts
+func countdown(n int) []int {
+	return opcode.MustAssemble(fmt.Sprintf(`
+		loop:    add [counter], #-1, [counter]
+		         jt [counter], #loop
+		         hlt
+		counter: data %d
+	`, n))
+}
+
+func TestStepDoesNotAllocate(t *testing.T) {
+	m := opcode.NewMachine(countdown(1<<40), nil, nil)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		_, err := m.Step()
+		require.NoError(t, err)
+	})
+	require.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkStep(b *testing.B) {
+	m := opcode.NewMachine(countdown(1<<40), nil, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.Step()
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		m := opcode.NewMachine(countdown(10000), nil, nil)
+		if _, err := m.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDay2Part2 brute-forces every noun and verb for the Day 2 program, like the solution does
+func BenchmarkDay2Part2(b *testing.B) {
+	input, err := utils.LoadInputFromPath("../inputs/day2.input")
+	if err != nil {
+		b.Skip("No input for Day 2")
+	}
+	codes := []int{}
+	for _, c := range strings.Split(input[0], ",") {
+		x, err := strconv.Atoi(c)
+		require.NoError(b, err)
+		codes = append(codes, x)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for noun := 0; noun < 100; noun++ {
+			for verb := 0; verb < 100; verb++ {
+				currCodes := append(codes[:0:0], codes...)
+				currCodes[1], currCodes[2] = noun, verb
+				if _, err := opcode.NewMachine(currCodes, nil, nil).Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	cases := map[string]struct {
+		codes        []int
+		expectedErr  error
+		expectedIP   int
+		expectedInst opcode.Instruction
+		expectedMode []opcode.Mode
+		relativeBase int
+	}{
+		"Unknown Instruction": {
+			codes:        []int{1101, 1, 1, 5, 42, 0},
+			expectedErr:  opcode.ErrUnknownInstruction,
+			expectedIP:   4,
+			expectedInst: 42,
+			expectedMode: []opcode.Mode{},
+		},
+		"Invalid Mode": {
+			codes:        []int{301, 0, 0, 0, 99},
+			expectedErr:  opcode.ErrInvalidMode,
+			expectedIP:   0,
+			expectedInst: opcode.InstructionAdd,
+			expectedMode: []opcode.Mode{3, opcode.ModePosition, opcode.ModePosition},
+		},
+		"Immediate Destination": {
+			codes:        []int{11101, 1, 1, 0, 99},
+			expectedErr:  opcode.ErrImmediateDestination,
+			expectedIP:   0,
+			expectedInst: opcode.InstructionAdd,
+			expectedMode: []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModeImmediate},
+		},
+		"Negative Read": {
+			codes:        []int{109, -10, 204, 3, 99},
+			expectedErr:  opcode.ErrNegativeAddress,
+			expectedIP:   2,
+			expectedInst: opcode.InstructionOutput,
+			expectedMode: []opcode.Mode{opcode.ModeRelative},
+			relativeBase: -10,
+		},
+		"Negative Write": {
+			codes:        []int{1101, 1, 1, -1, 99},
+			expectedErr:  opcode.ErrNegativeAddress,
+			expectedIP:   0,
+			expectedInst: opcode.InstructionAdd,
+			expectedMode: []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate, opcode.ModePosition},
+		},
+		"Jump To Negative Address": {
+			codes:        []int{1105, 1, -5},
+			expectedErr:  opcode.ErrNegativeAddress,
+			expectedIP:   0,
+			expectedInst: opcode.InstructionJumpTrue,
+			expectedMode: []opcode.Mode{opcode.ModeImmediate, opcode.ModeImmediate},
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewMachine(data.codes, nil, &opcode.SliceOutput{})
+		_, err := m.Run()
+		require.Truef(t, errors.Is(err, data.expectedErr), "Case %s: %v", name, err)
+		require.Equalf(t, err, m.Err(), "Case %s", name)
+
+		var execErr *opcode.ExecError
+		require.Truef(t, errors.As(err, &execErr), "Case %s", name)
+		require.Equalf(t, data.expectedIP, execErr.IP, "Case %s", name)
+		require.Equalf(t, data.expectedInst, execErr.Instruction, "Case %s", name)
+		require.Equalf(t, data.expectedMode, execErr.Modes, "Case %s", name)
+		require.Equalf(t, data.relativeBase, execErr.RelativeBase, "Case %s", name)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	const maxInt = int(^uint(0) >> 1)
+
+	cases := map[string]struct {
+		codes       []int
+		expectedErr error
+		expectedIP  int
+		operands    string
+		// lenient is true if the program runs without error when the machine isn't strict
+		lenient bool
+	}{
+		"Add Overflow": {
+			codes:       []int{1101, maxInt, 1, 5, 99, 0},
+			expectedErr: opcode.ErrOverflow,
+			operands:    fmt.Sprintf("add #%d, #1, [5]", maxInt),
+			lenient:     true,
+		},
+		"Add Underflow": {
+			codes:       []int{1, 6, 6, 6, 99, 0, -maxInt},
+			expectedErr: opcode.ErrOverflow,
+			operands:    fmt.Sprintf("add [6]=%d, [6]=%d, [6]", -maxInt, -maxInt),
+			lenient:     true,
+		},
+		"Multiply Overflow": {
+			codes:       []int{4, 0, 1102, 1 << 32, 1 << 32, 7, 99, 0},
+			expectedErr: opcode.ErrOverflow,
+			expectedIP:  2,
+			operands:    fmt.Sprintf("mul #%d, #%d, [7]", 1<<32, 1<<32),
+			lenient:     true,
+		},
+		"Multiply Smallest Int By -1": {
+			codes:       []int{1102, -1, -maxInt - 1, 5, 99, 0},
+			expectedErr: opcode.ErrOverflow,
+			operands:    fmt.Sprintf("mul #-1, #%d, [5]", -maxInt-1),
+			lenient:     true,
+		},
+		"Jump Past The End": {
+			codes:       []int{1105, 1, 100, 99},
+			expectedErr: opcode.ErrJumpOutOfRange,
+			operands:    "jt #1, #100",
+		},
+		"Jump Past The End To The Next Instruction": {
+			// The target is where the program would carry on anyway, but that is still past its end
+			codes:       []int{1105, 1, 3},
+			expectedErr: opcode.ErrJumpOutOfRange,
+			operands:    "jt #1, #3",
+		},
+		"Jump Into Memory Written Past The End": {
+			// Writing to address 50 grows the memory, but the program still ends at address 8
+			codes:       []int{1101, 0, 0, 50, 1105, 1, 40, 99},
+			expectedErr: opcode.ErrJumpOutOfRange,
+			expectedIP:  4,
+			operands:    "jt #1, #40",
+		},
+		"Jump To Negative Address": {
+			codes:       []int{1106, 0, -5, 99},
+			expectedErr: opcode.ErrJumpOutOfRange,
+			operands:    "jf #0, #-5",
+		},
+		"Negative Address": {
+			codes:       []int{1101, 1, 1, -1, 99},
+			expectedErr: opcode.ErrNegativeAddress,
+			operands:    "add #1, #1, [-1]",
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewMachine(append(data.codes[:0:0], data.codes...), nil, &opcode.SliceOutput{})
+		m.SetStrict(true)
+		_, err := m.Run()
+		require.Truef(t, errors.Is(err, data.expectedErr), "Case %s: %v", name, err)
+
+		var execErr *opcode.ExecError
+		require.Truef(t, errors.As(err, &execErr), "Case %s", name)
+		require.Equalf(t, data.expectedIP, execErr.IP, "Case %s", name)
+		require.Containsf(t, execErr.Error(), "Error executing "+data.operands+" (", "Case %s", name)
+
+		if data.lenient {
+			_, err = opcode.NewMachine(data.codes, nil, &opcode.SliceOutput{}).Run()
+			require.NoErrorf(t, err, "Case %s", name)
+		}
 	}
 }
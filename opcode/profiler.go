@@ -0,0 +1,292 @@
+package opcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// profileTableRows is how many of the most common entries are shown in each table of a profile report
+const profileTableRows = 10
+
+// modeNames are the names of the parameter modes, as shown in profile reports
+var modeNames = map[Mode]string{
+	ModePosition:  "position",
+	ModeImmediate: "immediate",
+	ModeRelative:  "relative",
+}
+
+// Profiler collects statistics about the instructions executed by the machines it is added to: how often each opcode,
+// address and parameter mode is used, how long is spent waiting for input, and which loops run the most. The same
+// profiler can be added to several machines at once, including machines running on different goroutines
+type Profiler struct {
+	lock         sync.Mutex
+	instructions int
+	opcodes      map[Instruction]int
+	// mnemonics holds the mnemonic of each opcode, from the instruction set of the machine that executed it
+	mnemonics map[Instruction]string
+	addresses map[int]*AddressCount
+	modes     map[Mode]int
+	// loops counts how many times each backward jump was taken
+	loops map[loop]int
+	// waiting holds when each machine started waiting for input
+	waiting   map[*Machine]time.Time
+	inputWait time.Duration
+}
+
+// loop is a backward jump from the end of a loop to its start
+type loop struct {
+	start, end int
+}
+
+// NewProfiler creates a profiler that hasn't seen any instructions yet
+func NewProfiler() *Profiler {
+	return &Profiler{
+		opcodes:   map[Instruction]int{},
+		mnemonics: map[Instruction]string{},
+		addresses: map[int]*AddressCount{},
+		modes:     map[Mode]int{},
+		loops:     map[loop]int{},
+		waiting:   map[*Machine]time.Time{},
+	}
+}
+
+// Hooks returns the hooks that collect the statistics, to be added to a machine with AddHooks
+func (p *Profiler) Hooks() Hooks {
+	return Hooks{
+		BeforeInstruction: p.beforeInstruction,
+		AfterInstruction:  p.afterInstruction,
+		Input:             p.input,
+	}
+}
+
+// beforeInstruction starts timing input instructions, which may have to wait
+func (p *Profiler) beforeInstruction(m *Machine, ip int, instruction Instruction, operands []Operand) {
+	if instruction != InstructionInput {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.waiting[m]; !ok {
+		p.waiting[m] = time.Now()
+	}
+}
+
+// afterInstruction counts an instruction once it has been executed. It isn't called while an input instruction is
+// waiting, so an instruction that is retried until its input arrives is only counted once
+func (p *Profiler) afterInstruction(m *Machine, ip int, instruction Instruction, operands []Operand) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	def, _ := m.instructions.Lookup(instruction)
+	p.instructions++
+	p.opcodes[instruction]++
+	p.mnemonics[instruction] = def.Mnemonic
+	count, ok := p.addresses[ip]
+	if !ok {
+		count = &AddressCount{Addr: ip}
+		p.addresses[ip] = count
+	}
+	count.Instruction = def.Mnemonic
+	count.Count++
+	for _, op := range operands {
+		p.modes[op.Mode]++
+	}
+
+	// Jumping back to this instruction or an earlier one is the end of a loop
+	if next := m.IP(); next <= ip && !m.Halted() {
+		p.loops[loop{start: next, end: ip}]++
+	}
+}
+
+// input records how long the machine waited for a value
+func (p *Profiler) input(m *Machine, val int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if start, ok := p.waiting[m]; ok {
+		p.inputWait += time.Since(start)
+		delete(p.waiting, m)
+	}
+}
+
+// OpcodeCount is how many times an instruction was executed
+type OpcodeCount struct {
+	Opcode   Instruction `json:"opcode"`
+	Mnemonic string      `json:"mnemonic"`
+	Count    int         `json:"count"`
+}
+
+// AddressCount is how many times the instruction at an address was executed
+type AddressCount struct {
+	Addr int `json:"addr"`
+	// Instruction is the mnemonic of the instruction most recently executed at the address
+	Instruction string `json:"instruction"`
+	Count       int    `json:"count"`
+}
+
+// ModeCount is how many parameters used a mode
+type ModeCount struct {
+	Mode  Mode   `json:"mode"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// LoopCount describes a loop, found by a jump from its end back to its start
+type LoopCount struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Iterations is how many times the jump back to the start was taken
+	Iterations int `json:"iterations"`
+	// Instructions is how many instructions were executed between the start and end of the loop, including those of
+	// any loops inside it
+	Instructions int `json:"instructions"`
+}
+
+// ProfileReport is a summary of the statistics collected by a Profiler. Each list is sorted with the most common
+// entries first
+type ProfileReport struct {
+	Instructions int `json:"instructions"`
+	// InputWait is how long machines spent waiting to read their inputs
+	InputWait time.Duration  `json:"input_wait_ns"`
+	Opcodes   []OpcodeCount  `json:"opcodes"`
+	Addresses []AddressCount `json:"addresses"`
+	Modes     []ModeCount    `json:"modes"`
+	Loops     []LoopCount    `json:"loops"`
+}
+
+// Report summarises the statistics collected so far
+func (p *Profiler) Report() *ProfileReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	r := &ProfileReport{
+		Instructions: p.instructions,
+		InputWait:    p.inputWait,
+		Opcodes:      []OpcodeCount{},
+		Addresses:    []AddressCount{},
+		Modes:        []ModeCount{},
+		Loops:        []LoopCount{},
+	}
+
+	for instruction, count := range p.opcodes {
+		r.Opcodes = append(r.Opcodes, OpcodeCount{Opcode: instruction, Mnemonic: p.mnemonics[instruction], Count: count})
+	}
+	sort.Slice(r.Opcodes, func(i, j int) bool {
+		a, b := r.Opcodes[i], r.Opcodes[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Opcode < b.Opcode
+	})
+
+	for _, count := range p.addresses {
+		r.Addresses = append(r.Addresses, *count)
+	}
+	sort.Slice(r.Addresses, func(i, j int) bool {
+		a, b := r.Addresses[i], r.Addresses[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Addr < b.Addr
+	})
+
+	for mode, count := range p.modes {
+		name, ok := modeNames[mode]
+		if !ok {
+			name = fmt.Sprintf("mode%d", int(mode))
+		}
+		r.Modes = append(r.Modes, ModeCount{Mode: mode, Name: name, Count: count})
+	}
+	sort.Slice(r.Modes, func(i, j int) bool {
+		a, b := r.Modes[i], r.Modes[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Mode < b.Mode
+	})
+
+	for l, iterations := range p.loops {
+		count := LoopCount{Start: l.start, End: l.end, Iterations: iterations}
+		for addr := l.start; addr <= l.end; addr++ {
+			if c, ok := p.addresses[addr]; ok {
+				count.Instructions += c.Count
+			}
+		}
+		r.Loops = append(r.Loops, count)
+	}
+	sort.Slice(r.Loops, func(i, j int) bool {
+		a, b := r.Loops[i], r.Loops[j]
+		if a.Instructions != b.Instructions {
+			return a.Instructions > b.Instructions
+		}
+		return a.Start < b.Start || a.Start == b.Start && a.End < b.End
+	})
+
+	return r
+}
+
+// WriteJSON writes the report to `w` as JSON
+func (r *ProfileReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// WriteTable writes the report to `w` as text tables, showing only the most common entries of each
+func (r *ProfileReport) WriteTable(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("Instructions executed: %d\n", r.Instructions)
+	ew.printf("Waiting for input:     %v\n", r.InputWait)
+
+	ew.printf("\n%-10s %12s %7s\n", "Opcode", "Count", "%")
+	for _, c := range r.Opcodes[:rows(len(r.Opcodes))] {
+		ew.printf("%-10s %12d %7s\n", c.Mnemonic, c.Count, r.percent(c.Count))
+	}
+
+	ew.printf("\n%-10s %12s %7s\n", "Mode", "Count", "%")
+	total := 0
+	for _, c := range r.Modes {
+		total += c.Count
+	}
+	for _, c := range r.Modes[:rows(len(r.Modes))] {
+		ew.printf("%-10s %12d %7s\n", c.Name, c.Count, percent(c.Count, total))
+	}
+
+	ew.printf("\n%-10s %-11s %12s %7s\n", "Address", "Instruction", "Count", "%")
+	for _, c := range r.Addresses[:rows(len(r.Addresses))] {
+		ew.printf("%04d       %-11s %12d %7s\n", c.Addr, c.Instruction, c.Count, r.percent(c.Count))
+	}
+
+	ew.printf("\n%-10s %12s %12s %7s\n", "Loop", "Iterations", "Executed", "%")
+	for _, c := range r.Loops[:rows(len(r.Loops))] {
+		ew.printf("%-10s %12d %12d %7s\n", fmt.Sprintf("%04d-%04d", c.Start, c.End), c.Iterations, c.Instructions,
+			r.percent(c.Instructions))
+	}
+	return ew.err
+}
+
+// percent formats a count as a percentage of the instructions executed
+func (r *ProfileReport) percent(count int) string {
+	return percent(count, r.Instructions)
+}
+
+// percent formats a count as a percentage of a total
+func percent(count, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(count)/float64(total))
+}
+
+// rows returns how many rows of a table with `n` entries to show
+func rows(n int) int {
+	if n > profileTableRows {
+		return profileTableRows
+	}
+	return n
+}
+
+// errWriter writes formatted text until the first error, which it remembers
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+// printf writes formatted text, unless there has already been an error
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
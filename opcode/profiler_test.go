@@ -0,0 +1,113 @@
+package opcode_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestProfiler(t *testing.T) {
+	// Counts down from 3, outputting each value
+	codes := opcode.MustAssemble(`
+		loop:    add [counter], #-1, [counter]
+		         out [counter]
+		         jt [counter], #loop
+		         hlt
+		counter: data 3
+	`)
+
+	p := opcode.NewProfiler()
+	m := opcode.NewMachine(codes, nil, &opcode.SliceOutput{})
+	m.AddHooks(p.Hooks())
+	_, err := m.Run()
+	require.NoError(t, err)
+
+	report := p.Report()
+	require.Equal(t, 10, report.Instructions)
+	require.Equal(t, []opcode.OpcodeCount{
+		{Opcode: opcode.InstructionAdd, Mnemonic: "add", Count: 3},
+		{Opcode: opcode.InstructionOutput, Mnemonic: "out", Count: 3},
+		{Opcode: opcode.InstructionJumpTrue, Mnemonic: "jt", Count: 3},
+		{Opcode: opcode.InstructionHalt, Mnemonic: "hlt", Count: 1},
+	}, report.Opcodes)
+	require.Equal(t, []opcode.AddressCount{
+		{Addr: 0, Instruction: "add", Count: 3},
+		{Addr: 4, Instruction: "out", Count: 3},
+		{Addr: 6, Instruction: "jt", Count: 3},
+		{Addr: 9, Instruction: "hlt", Count: 1},
+	}, report.Addresses)
+	require.Equal(t, []opcode.ModeCount{
+		{Mode: opcode.ModePosition, Name: "position", Count: 12},
+		{Mode: opcode.ModeImmediate, Name: "immediate", Count: 6},
+	}, report.Modes)
+	require.Equal(t, []opcode.LoopCount{
+		{Start: 0, End: 6, Iterations: 2, Instructions: 9},
+	}, report.Loops)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, report.WriteJSON(buf))
+	decoded := &opcode.ProfileReport{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), decoded))
+	require.Equal(t, report, decoded)
+
+	buf.Reset()
+	require.NoError(t, report.WriteTable(buf))
+	require.Contains(t, buf.String(), "Instructions executed: 10\n")
+	require.Contains(t, buf.String(), "add                   3   30.0%\n")
+	require.Contains(t, buf.String(), "position             12   66.7%\n")
+	require.Contains(t, buf.String(), "0006       jt                     3   30.0%\n")
+	require.Contains(t, buf.String(), "0000-0006             2            9   90.0%\n")
+}
+
+func TestProfilerRetriedInput(t *testing.T) {
+	p := opcode.NewProfiler()
+	m := opcode.NewSyncMachine([]int{3, 0, 99})
+	m.AddHooks(p.Hooks())
+
+	// The input instruction is tried three times before there is an input for it
+	for i := 0; i < 3; i++ {
+		status, err := m.RunUntilInput()
+		require.NoError(t, err)
+		require.Equal(t, opcode.StatusNeedsInput, status)
+	}
+	m.PushInput(1)
+	status, err := m.RunUntilInput()
+	require.NoError(t, err)
+	require.Equal(t, opcode.StatusHalted, status)
+
+	report := p.Report()
+	require.Equal(t, 2, report.Instructions)
+	require.Equal(t, []opcode.OpcodeCount{
+		{Opcode: opcode.InstructionInput, Mnemonic: "in", Count: 1},
+		{Opcode: opcode.InstructionHalt, Mnemonic: "hlt", Count: 1},
+	}, report.Opcodes)
+	require.Equal(t, []opcode.AddressCount{
+		{Addr: 0, Instruction: "in", Count: 1},
+		{Addr: 2, Instruction: "hlt", Count: 1},
+	}, report.Addresses)
+	require.Equal(t, []opcode.ModeCount{
+		{Mode: opcode.ModePosition, Name: "position", Count: 1},
+	}, report.Modes)
+}
+
+func TestProfilerInputWait(t *testing.T) {
+	p := opcode.NewProfiler()
+	in := opcode.NewQueue()
+	m := opcode.NewMachine([]int{3, 0, 99}, in, nil)
+	m.AddHooks(p.Hooks())
+
+	wait := 20 * time.Millisecond
+	go func() {
+		time.Sleep(wait)
+		in.Write(context.Background(), 1)
+	}()
+	_, err := m.Run()
+	require.NoError(t, err)
+	require.True(t, p.Report().InputWait >= wait)
+}
@@ -0,0 +1,63 @@
+package opcode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// profile is the instructions and modes supported at one stage of building the Intcode computer
+type profile struct {
+	instructions []Instruction
+	modes        []Mode
+}
+
+var (
+	day2Instructions = []Instruction{InstructionAdd, InstructionMultiply, InstructionHalt}
+	day5Instructions = append(day2Instructions[:len(day2Instructions):len(day2Instructions)],
+		InstructionInput, InstructionOutput, InstructionJumpTrue, InstructionJumpFalse, InstructionLessThan,
+		InstructionEquals)
+	day9Instructions = append(day5Instructions[:len(day5Instructions):len(day5Instructions)],
+		InstructionRelativeBaseOffset)
+)
+
+// profiles maps the name of each profile to what it supports
+var profiles = map[string]profile{
+	"day2": {day2Instructions, []Mode{ModePosition}},
+	"day5": {day5Instructions, []Mode{ModePosition, ModeImmediate}},
+	"day9": {day9Instructions, []Mode{ModePosition, ModeImmediate, ModeRelative}},
+	"full": {day9Instructions, []Mode{ModePosition, ModeImmediate, ModeRelative}},
+}
+
+// Profile returns a new instruction set with only the instructions and parameter modes of the named stage of the
+// Intcode computer, so a machine using it rejects anything a program of that stage shouldn't contain:
+//
+//	day2  add, mul and hlt, in position mode only
+//	day5  adds in, out, jt, jf, lt and eq, and immediate mode
+//	day9  adds arb and relative mode, for the complete computer. This is also called full
+func Profile(name string) (*InstructionSet, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown profile %q, expected one of %v", name, ProfileNames())
+	}
+
+	// The profile shares its definitions with DefaultInstructions, rather than registering copies, so that machines
+	// using it can still be compiled
+	s := &InstructionSet{}
+	for _, instruction := range p.instructions {
+		s.defs[instruction] = DefaultInstructions.defs[instruction]
+	}
+	if err := s.RestrictModes(p.modes...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ProfileNames returns the names of all the profiles, in alphabetical order
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
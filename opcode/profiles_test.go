@@ -0,0 +1,75 @@
+package opcode_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestProfiles(t *testing.T) {
+	cases := map[string]struct {
+		profile     string
+		codes       []int
+		expectedErr error
+	}{
+		"Day 2 Add And Multiply": {
+			profile: "day2",
+			codes:   []int{1, 9, 10, 3, 2, 3, 11, 0, 99, 30, 40, 50},
+		},
+		"Day 2 Rejects Input": {
+			profile:     "day2",
+			codes:       []int{3, 0, 99},
+			expectedErr: opcode.ErrUnknownInstruction,
+		},
+		"Day 2 Rejects Immediate Mode": {
+			profile:     "day2",
+			codes:       []int{1101, 1, 1, 0, 99},
+			expectedErr: opcode.ErrInvalidMode,
+		},
+		"Day 5 Immediate Mode": {
+			profile: "day5",
+			codes:   []int{1101, 1, 1, 0, 1105, 1, 8, 0, 104, 7, 99},
+		},
+		"Day 5 Rejects Relative Base Offset": {
+			profile:     "day5",
+			codes:       []int{109, 1, 99},
+			expectedErr: opcode.ErrUnknownInstruction,
+		},
+		"Day 5 Rejects Relative Mode": {
+			profile:     "day5",
+			codes:       []int{204, 0, 99},
+			expectedErr: opcode.ErrInvalidMode,
+		},
+		"Day 9 Relative Mode": {
+			profile: "day9",
+			codes:   []int{109, 1, 204, -1, 99},
+		},
+		"Full Relative Mode": {
+			profile: "full",
+			codes:   []int{109, 1, 204, -1, 99},
+		},
+	}
+
+	for name, data := range cases {
+		profile, err := opcode.Profile(data.profile)
+		require.NoErrorf(t, err, "Case %s", name)
+
+		m := opcode.NewMachine(data.codes, nil, &opcode.SliceOutput{})
+		m.SetInstructionSet(profile)
+		_, err = m.Run()
+		if data.expectedErr == nil {
+			require.NoErrorf(t, err, "Case %s", name)
+			require.Truef(t, m.Halted(), "Case %s", name)
+		} else {
+			require.Truef(t, errors.Is(err, data.expectedErr), "Case %s: %v", name, err)
+		}
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	_, err := opcode.Profile("day3")
+	require.EqualError(t, err, "Unknown profile \"day3\", expected one of [day2 day5 day9 full]")
+}
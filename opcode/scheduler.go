@@ -0,0 +1,134 @@
+package opcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoBuffers is returned when adding a machine to a Scheduler, or searching its inputs with Search, if it doesn't
+// buffer its inputs and outputs as a machine created by NewSyncMachine does
+var ErrNoBuffers = errors.New("Machine has no input and output buffers")
+
+// Scheduler runs a group of connected machines on a single goroutine. The machines take turns in the order they were
+// added, each running until it needs an input it hasn't been given yet, and then its outputs are given to the machines
+// it is connected to. Because nothing runs concurrently, the machines run exactly the same way every time
+type Scheduler struct {
+	machines []*Machine
+	targets  [][]int
+	// outputs holds every value output by each machine
+	outputs [][]int
+}
+
+// NewScheduler creates a scheduler with no machines
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add adds a machine to the scheduler and returns its ID, which counts up from 0. The machine must have been created by
+// NewSyncMachine, and not given another input or output since, or Add fails with ErrNoBuffers. Any inputs it has
+// already been given are read before those from other machines
+func (s *Scheduler) Add(m *Machine) (int, error) {
+	if m.inputs == nil || m.outputs == nil {
+		return 0, ErrNoBuffers
+	}
+	s.machines = append(s.machines, m)
+	s.targets = append(s.targets, nil)
+	s.outputs = append(s.outputs, nil)
+	return len(s.machines) - 1, nil
+}
+
+// Machine returns the machine with the given ID
+func (s *Scheduler) Machine(id int) *Machine {
+	return s.machines[id]
+}
+
+// Connect gives every output of machine `from` to machine `to` as an input. A machine connected to several others
+// gives each of them every output
+func (s *Scheduler) Connect(from, to int) error {
+	if !s.valid(from) || !s.valid(to) {
+		return fmt.Errorf("Can't connect machine %d to machine %d, there are only %d machines", from, to, len(s.machines))
+	}
+	s.targets[from] = append(s.targets[from], to)
+	return nil
+}
+
+// Outputs returns every value output by a machine so far
+func (s *Scheduler) Outputs(id int) []int {
+	return s.outputs[id]
+}
+
+// Run gives the machines turns until they have all halted. It fails if a machine fails, or with a DeadlockError if the
+// machines are all waiting for each other
+func (s *Scheduler) Run() error {
+	for {
+		running := false
+		for id, m := range s.machines {
+			if m.Halted() {
+				continue
+			}
+			if err := s.turn(id); err != nil {
+				return err
+			}
+			running = running || !m.Halted()
+		}
+		if !running {
+			return nil
+		}
+
+		if s.blocked() {
+			return s.deadlock()
+		}
+	}
+}
+
+// turn runs a machine until it needs an input it hasn't been given, then passes on its outputs
+func (s *Scheduler) turn(id int) error {
+	m := s.machines[id]
+	if _, err := m.RunUntilInput(); err != nil {
+		return fmt.Errorf("Machine %d: %w", id, err)
+	}
+
+	outputs := m.Outputs()
+	s.outputs[id] = append(s.outputs[id], outputs...)
+	for _, target := range s.targets[id] {
+		s.machines[target].PushInput(outputs...)
+	}
+	return nil
+}
+
+// blocked returns true iff no machine that is still running has any input waiting for it
+func (s *Scheduler) blocked() bool {
+	for _, m := range s.machines {
+		if !m.Halted() && m.inputs.Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// deadlock describes what each of the machines still running is waiting for
+func (s *Scheduler) deadlock() error {
+	sources := make([][]int, len(s.machines))
+	for id, targets := range s.targets {
+		for _, target := range targets {
+			sources[target] = append(sources[target], id)
+		}
+	}
+
+	halted := func(id int) bool {
+		return s.machines[id].Halted()
+	}
+
+	err := &DeadlockError{}
+	for id, m := range s.machines {
+		if !m.Halted() {
+			err.Waiting = append(err.Waiting, newWaiting(id, m, sources[id], halted))
+		}
+	}
+	return err
+}
+
+// valid returns true iff the machine ID exists
+func (s *Scheduler) valid(id int) bool {
+	return id >= 0 && id < len(s.machines)
+}
@@ -0,0 +1,157 @@
+package opcode_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+// addMachine adds a machine to a scheduler, failing the test if it can't be added
+func addMachine(t *testing.T, s *opcode.Scheduler, m *opcode.Machine) int {
+	id, err := s.Add(m)
+	require.NoError(t, err)
+	return id
+}
+
+func TestScheduler(t *testing.T) {
+	feedback := []int{3, 26, 1001, 26, -4, 26, 3, 27, 1002, 27, 2, 27, 1, 27, 26, 27, 4, 27, 1001, 28, -1, 28, 1005, 28, 6, 99, 0, 0, 5}
+	// Outputs the first four inputs it is given
+	collect := []int{3, 0, 4, 0, 3, 0, 4, 0, 3, 0, 4, 0, 3, 0, 4, 0, 99}
+
+	cases := map[string]struct {
+		build   func(s *opcode.Scheduler)
+		id      int
+		outputs []int
+	}{
+		"Ring": {
+			build: func(s *opcode.Scheduler) {
+				for i, phase := range []int{9, 8, 7, 6, 5} {
+					m := opcode.NewSyncMachine(append(feedback[:0:0], feedback...))
+					m.PushInput(phase)
+					addMachine(t, s, m)
+					if i > 0 {
+						require.NoError(t, s.Connect(i-1, i))
+					}
+				}
+				s.Machine(0).PushInput(0)
+				require.NoError(t, s.Connect(4, 0))
+			},
+			id:      4,
+			outputs: []int{129, 4257, 136353, 4363425, 139629729},
+		},
+		"Fan in": {
+			build: func(s *opcode.Scheduler) {
+				a := addMachine(t, s, opcode.NewSyncMachine([]int{104, 1, 104, 2, 99}))
+				b := addMachine(t, s, opcode.NewSyncMachine([]int{104, 3, 104, 4, 99}))
+				c := addMachine(t, s, opcode.NewSyncMachine(append(collect[:0:0], collect...)))
+				require.NoError(t, s.Connect(a, c))
+				require.NoError(t, s.Connect(b, c))
+			},
+			id:      2,
+			outputs: []int{1, 2, 3, 4},
+		},
+	}
+
+	for name, data := range cases {
+		// The machines always take their turns in the same order, so repeated runs are identical
+		for i := 0; i < 3; i++ {
+			s := opcode.NewScheduler()
+			data.build(s)
+			require.NoErrorf(t, s.Run(), "Case %s", name)
+			require.Equal(t, data.outputs, s.Outputs(data.id), "Case %s", name)
+		}
+	}
+}
+
+func TestSchedulerErrors(t *testing.T) {
+	// The scheduler can't pass values to or from a machine that reads and writes them somewhere else
+	s := opcode.NewScheduler()
+	_, err := s.Add(opcode.NewMachine([]int{3, 0, 4, 0, 99}, opcode.NewSliceInput(), &opcode.SliceOutput{}))
+	require.Equal(t, opcode.ErrNoBuffers, err)
+	m := opcode.NewSyncMachine([]int{3, 0, 4, 0, 99})
+	m.SetOutput(&opcode.SliceOutput{})
+	_, err = s.Add(m)
+	require.Equal(t, opcode.ErrNoBuffers, err)
+
+	s = opcode.NewScheduler()
+	addMachine(t, s, opcode.NewSyncMachine([]int{99}))
+	require.EqualError(t, s.Connect(0, 1), "Can't connect machine 0 to machine 1, there are only 1 machines")
+
+	// Two machines both waiting for the other to start
+	s = opcode.NewScheduler()
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 0, 4, 0, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 0, 4, 0, 99}))
+	require.NoError(t, s.Connect(0, 1))
+	require.NoError(t, s.Connect(1, 0))
+	err = s.Run()
+	require.True(t, errors.Is(err, opcode.ErrDeadlock))
+	require.EqualError(t, err, "Every machine is waiting for input: machine 0 at IP 0 (in [0]) is waiting for machine 1; machine 1 at IP 0 (in [0]) is waiting for machine 0")
+
+	// A machine that has halted isn't waiting, and the machines it was connected to, or with nothing connected to them,
+	// will never get any input
+	s = opcode.NewScheduler()
+	addMachine(t, s, opcode.NewSyncMachine([]int{104, 1, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 9, 3, 9, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{1101, 1, 2, 0, 3, 0, 99}))
+	require.NoError(t, s.Connect(0, 1))
+	err = s.Run()
+	var deadlock *opcode.DeadlockError
+	require.True(t, errors.As(err, &deadlock))
+	require.Equal(t, []opcode.Waiting{
+		{ID: 1, IP: 2, Instruction: "in [9]", Halted: []int{0}},
+		{ID: 2, IP: 4, Instruction: "in [0]"},
+	}, deadlock.Waiting)
+	require.EqualError(t, err, "Every machine is waiting for input: machine 1 at IP 2 (in [9]) won't get any more input, machine 0 has halted; machine 2 at IP 4 (in [0]) has nothing connected to it")
+
+	// Only machines that are still running are given as what a machine is waiting for
+	s = opcode.NewScheduler()
+	addMachine(t, s, opcode.NewSyncMachine([]int{104, 1, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 0, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 0, 3, 0, 99}))
+	require.NoError(t, s.Connect(0, 2))
+	require.NoError(t, s.Connect(1, 2))
+	err = s.Run()
+	require.EqualError(t, err, "Every machine is waiting for input: machine 1 at IP 0 (in [0]) has nothing connected to it; machine 2 at IP 2 (in [0]) is waiting for machine 1")
+
+	s = opcode.NewScheduler()
+	addMachine(t, s, opcode.NewSyncMachine([]int{104, 1, 99}))
+	addMachine(t, s, opcode.NewSyncMachine([]int{3, 0, 42}))
+	require.NoError(t, s.Connect(0, 1))
+	err = s.Run()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Machine 1: ")
+	var execErr *opcode.ExecError
+	require.True(t, errors.As(err, &execErr))
+	require.True(t, errors.Is(execErr.Err, opcode.ErrUnknownInstruction))
+}
+
+func TestWaitingString(t *testing.T) {
+	cases := map[string]struct {
+		waiting  opcode.Waiting
+		expected string
+	}{
+		"Nothing connected": {
+			waiting:  opcode.Waiting{ID: 0, IP: 4, Instruction: "in [0]"},
+			expected: "machine 0 at IP 4 (in [0]) has nothing connected to it",
+		},
+		"Running sources": {
+			waiting:  opcode.Waiting{ID: 2, IP: 0, Instruction: "in [0]", Sources: []int{0, 1}, Halted: []int{3}},
+			expected: "machine 2 at IP 0 (in [0]) is waiting for machine 0 or 1",
+		},
+		"Halted source": {
+			waiting:  opcode.Waiting{ID: 1, IP: 0, Instruction: "in [0]", Halted: []int{0}},
+			expected: "machine 1 at IP 0 (in [0]) won't get any more input, machine 0 has halted",
+		},
+		"Halted sources": {
+			waiting:  opcode.Waiting{ID: 2, IP: 0, Instruction: "in [0]", Halted: []int{0, 1}},
+			expected: "machine 2 at IP 0 (in [0]) won't get any more input, machines 0 and 1 have halted",
+		},
+	}
+
+	for name, data := range cases {
+		require.Equalf(t, data.expected, data.waiting.String(), "Case %s", name)
+	}
+}
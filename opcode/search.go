@@ -0,0 +1,62 @@
+package opcode
+
+// SearchNode is a state reached while searching the inputs of a machine
+type SearchNode struct {
+	// Machine is the machine in this state. It is waiting for an input, or has halted
+	Machine *Machine
+	// Path is the inputs that were given to the original machine to reach this state, in order
+	Path []int
+	// Outputs are the values the machine wrote after its last input
+	Outputs []int
+}
+
+// Search explores the states a machine can reach by giving it different inputs, breadth first. The machine must have
+// been created by NewSyncMachine, or Search fails with ErrNoBuffers, and is not changed by the search
+//
+// From every state, each of `inputs` is given to its own clone of the machine, which then runs until it needs another
+// input or halts. `visit` is called with every state reached this way, in order of the length of their paths, and
+// decides whether the search should carry on from that state, and whether the search is done. Search returns the state
+// the search finished at, or nil if there were no more states to explore
+func Search(m *Machine, inputs []int, visit func(node *SearchNode) (expand, done bool)) (*SearchNode, error) {
+	if m.inputs == nil || m.outputs == nil {
+		return nil, ErrNoBuffers
+	}
+
+	// Outputs from before the search started don't belong to any of the states
+	root, err := m.Clone()
+	if err != nil {
+		return nil, err
+	}
+	root.Outputs()
+
+	queue := []*SearchNode{{Machine: root}}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for _, input := range inputs {
+			c, err := node.Machine.Clone()
+			if err != nil {
+				return nil, err
+			}
+			c.PushInput(input)
+			if _, err := c.RunUntilInput(); err != nil {
+				return nil, err
+			}
+
+			next := &SearchNode{
+				Machine: c,
+				Path:    append(append(make([]int, 0, len(node.Path)+1), node.Path...), input),
+				Outputs: c.Outputs(),
+			}
+			expand, done := visit(next)
+			if done {
+				return next, nil
+			}
+			if expand && !c.halted {
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, nil
+}
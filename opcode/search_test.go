@@ -0,0 +1,129 @@
+package opcode_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestMachineClone(t *testing.T) {
+	m := opcode.NewSyncMachine(runningTotal())
+	m.PushInput(5)
+	_, err := m.RunUntilInput()
+	require.NoError(t, err)
+
+	clone, err := m.Clone()
+	require.NoError(t, err)
+	m.PushInput(1)
+	clone.PushInput(2, 3)
+
+	_, err = m.RunUntilInput()
+	require.NoError(t, err)
+	_, err = clone.RunUntilInput()
+	require.NoError(t, err)
+
+	// Both have the output from before they were cloned, then go their own ways
+	require.Equal(t, []int{5, 6}, m.Outputs())
+	require.Equal(t, []int{5, 7, 10}, clone.Outputs())
+
+	val, err := m.Memory().Read(1000000)
+	require.NoError(t, err)
+	require.Equal(t, 6, val)
+	val, err = clone.Memory().Read(1000000)
+	require.NoError(t, err)
+	require.Equal(t, 10, val)
+}
+
+func TestMachineCloneUnsupportedIO(t *testing.T) {
+	// Both machines would close the same channel when they halted
+	m := opcode.NewMachine([]int{104, 1, 99}, nil, make(opcode.ChanOutput, 1))
+	_, err := m.Clone()
+	require.EqualError(t, err, "Can't clone input or output: output of type opcode.ChanOutput")
+	require.True(t, errors.Is(err, opcode.ErrCloneIO))
+
+	m = opcode.NewMachine([]int{3, 0, 99}, opcode.NewQueue(1), nil)
+	_, err = m.Clone()
+	require.True(t, errors.Is(err, opcode.ErrCloneIO))
+}
+
+func TestSearchWithoutBuffers(t *testing.T) {
+	visit := func(node *opcode.SearchNode) (bool, bool) {
+		return true, false
+	}
+
+	// Searching needs the buffers of a machine created by NewSyncMachine, not just slices for its input and output
+	m := opcode.NewMachine([]int{3, 0, 99}, opcode.NewSliceInput(), &opcode.SliceOutput{})
+	_, err := opcode.Search(m, []int{1}, visit)
+	require.Equal(t, opcode.ErrNoBuffers, err)
+
+	m = opcode.NewMachine([]int{3, 0, 99}, opcode.NewQueue(1), nil)
+	_, err = opcode.Search(m, []int{1}, visit)
+	require.Equal(t, opcode.ErrNoBuffers, err)
+}
+
+func TestSearch(t *testing.T) {
+	// Checks the combination 3, 1, 2 one digit at a time, outputting 1 for a correct digit, 2 once the combination is
+	// complete, and 0 for a wrong digit
+	lock := opcode.MustAssemble(`
+		       in [x]
+		       eq [x], #3, [ok]
+		       jf [ok], #wrong
+		       out #1
+		       in [x]
+		       eq [x], #1, [ok]
+		       jf [ok], #wrong
+		       out #1
+		       in [x]
+		       eq [x], #2, [ok]
+		       jf [ok], #wrong
+		       out #2
+		       hlt
+		wrong: out #0
+		       hlt
+		x:     data 0
+		ok:    data 0
+	`)
+
+	cases := map[string]struct {
+		inputs   []int
+		path     []int
+		visited  int
+		notFound bool
+	}{
+		"Found": {
+			inputs:  []int{1, 2, 3},
+			path:    []int{3, 1, 2},
+			visited: 8,
+		},
+		"Not Found": {
+			inputs:   []int{1, 2},
+			visited:  2,
+			notFound: true,
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewSyncMachine(append(lock[:0:0], lock...))
+		visited := 0
+		node, err := opcode.Search(m, data.inputs, func(node *opcode.SearchNode) (bool, bool) {
+			visited++
+			return node.Outputs[0] == 1, node.Outputs[0] == 2
+		})
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equalf(t, data.visited, visited, "Case %s", name)
+
+		if data.notFound {
+			require.Nilf(t, node, "Case %s", name)
+			continue
+		}
+		require.Equalf(t, data.path, node.Path, "Case %s", name)
+		require.Equalf(t, []int{2}, node.Outputs, "Case %s", name)
+		require.Truef(t, node.Machine.Halted(), "Case %s", name)
+
+		// The original machine hasn't moved
+		require.Equalf(t, 0, m.IP(), "Case %s", name)
+	}
+}
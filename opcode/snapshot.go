@@ -0,0 +1,140 @@
+package opcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this package
+const SnapshotVersion = 1
+
+var (
+	// ErrSnapshotVersion is returned when loading or restoring a snapshot written in a format this package doesn't
+	// understand
+	ErrSnapshotVersion = errors.New("Unsupported snapshot version")
+	// ErrInvalidSnapshot is returned when restoring a snapshot whose state doesn't make sense
+	ErrInvalidSnapshot = errors.New("Invalid snapshot")
+	// ErrSnapshotIO is returned when snapshotting a machine whose input or output holds state that can't be saved
+	ErrSnapshotIO = errors.New("Can't snapshot input or output")
+)
+
+// Snapshot is the saved state of a machine. It can be written to disk and restored later, possibly on another
+// computer
+type Snapshot struct {
+	// Version is the version of the snapshot format
+	Version int `json:"version"`
+
+	IP           int `json:"ip"`
+	RelativeBase int `json:"relative_base"`
+	// Memory is the dense part of the machine's memory, starting from address 0
+	Memory []int `json:"memory"`
+	// Sparse holds values from far beyond the end of the dense memory
+	Sparse map[int]int `json:"sparse,omitempty"`
+	// Loaded is the length of the program the machine was created with, before the memory grew
+	Loaded int `json:"loaded,omitempty"`
+
+	Halted bool `json:"halted,omitempty"`
+	// Error is the message of the error that stopped the machine, if any
+	Error string `json:"error,omitempty"`
+
+	// Inputs are values that have been given to the machine but not yet read
+	Inputs []int `json:"inputs,omitempty"`
+	// Outputs are values the machine has written but have not yet been collected
+	Outputs []int `json:"outputs,omitempty"`
+}
+
+// Snapshot captures the current state of the machine. Pending inputs are included if the machine reads from a
+// SliceInput or a Queue, and pending outputs are included if it writes to a SliceOutput. Any other input or output
+// can't be saved, so snapshotting a machine that uses one fails with ErrSnapshotIO
+func (m *Machine) Snapshot() (*Snapshot, error) {
+	switch m.in.(type) {
+	case nil, *SliceInput, *Queue:
+	default:
+		return nil, fmt.Errorf("%w: input of type %T", ErrSnapshotIO, m.in)
+	}
+	switch m.out.(type) {
+	case nil, *SliceOutput:
+	default:
+		return nil, fmt.Errorf("%w: output of type %T", ErrSnapshotIO, m.out)
+	}
+
+	s := &Snapshot{
+		Version:      SnapshotVersion,
+		IP:           m.ip,
+		RelativeBase: m.relativeBase,
+		Memory:       m.memory.Slice(),
+		Loaded:       m.memory.loaded,
+		Halted:       m.halted,
+	}
+
+	if len(m.memory.sparse) > 0 {
+		s.Sparse = make(map[int]int, len(m.memory.sparse))
+		for addr, val := range m.memory.sparse {
+			s.Sparse[addr] = val
+		}
+	}
+	if m.err != nil {
+		s.Error = m.err.Error()
+	}
+
+	switch in := m.in.(type) {
+	case *SliceInput:
+		s.Inputs = append(s.Inputs, in.values...)
+	case *Queue:
+		in.lock.Lock()
+		s.Inputs = append(s.Inputs, in.values...)
+		in.lock.Unlock()
+	}
+	if out, ok := m.out.(*SliceOutput); ok {
+		s.Outputs = append(s.Outputs, out.Values...)
+	}
+	return s, nil
+}
+
+// Restore creates a machine from a snapshot. The machine is created in the same way as NewSyncMachine, with any
+// pending inputs and outputs from the snapshot in its buffers. SetInput and SetOutput can be used to connect it to
+// something else
+func Restore(s *Snapshot) (*Machine, error) {
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w %d", ErrSnapshotVersion, s.Version)
+	}
+
+	m := NewSyncMachine(append([]int{}, s.Memory...))
+	for addr, val := range s.Sparse {
+		if addr < len(s.Memory) {
+			return nil, fmt.Errorf("%w: sparse address %d overlaps the dense memory", ErrInvalidSnapshot, addr)
+		}
+		m.memory.sparse[addr] = val
+	}
+	if s.Loaded > 0 {
+		m.memory.loaded = s.Loaded
+	}
+	m.ip, m.relativeBase = s.IP, s.RelativeBase
+	m.halted = s.Halted
+	if s.Error != "" {
+		m.err = errors.New(s.Error)
+	}
+
+	m.PushInput(s.Inputs...)
+	m.outputs.Values = append(m.outputs.Values, s.Outputs...)
+	return m, nil
+}
+
+// Save writes the snapshot to `w` as JSON
+func (s *Snapshot) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// LoadSnapshot reads a snapshot that was written by Save
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w %d", ErrSnapshotVersion, s.Version)
+	}
+	return s, nil
+}
@@ -0,0 +1,116 @@
+package opcode_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+// runningTotal returns a program that outputs the running total of its inputs, keeping a copy at address 1000000
+func runningTotal() []int {
+	return opcode.MustAssemble(`
+		loop: in [x]
+		      add [x], [total], [total]
+		      add [total], #0, [1000000]
+		      out [total]
+		      jt #1, #loop
+		x:     data 0
+		total: data 0
+	`)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	m := opcode.NewSyncMachine(runningTotal())
+	m.PushInput(1, 2, 3)
+	_, err := m.Run()
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, m.Outputs())
+	_, err = m.Run()
+	require.NoError(t, err)
+
+	// Save the machine part way through, with an input and an output pending
+	buf := &bytes.Buffer{}
+	s, err := m.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, s.Save(buf))
+
+	snapshot, err := opcode.LoadSnapshot(buf)
+	require.NoError(t, err)
+	restored, err := opcode.Restore(snapshot)
+	require.NoError(t, err)
+	require.Equal(t, m.IP(), restored.IP())
+	require.Equal(t, m.RelativeBase(), restored.RelativeBase())
+	require.Equal(t, m.Memory().Slice(), restored.Memory().Slice())
+
+	// Both machines carry on in exactly the same way
+	for _, machine := range []*opcode.Machine{m, restored} {
+		require.Equal(t, []int{3}, machine.Outputs())
+		status, err := machine.RunUntilInput()
+		require.NoError(t, err)
+		require.Equal(t, opcode.StatusNeedsInput, status)
+		require.Equal(t, []int{6}, machine.Outputs())
+
+		far, err := machine.Memory().Read(1000000)
+		require.NoError(t, err)
+		require.Equal(t, 6, far)
+	}
+}
+
+func TestSnapshotHalted(t *testing.T) {
+	m := opcode.NewSyncMachine([]int{42})
+	_, runErr := m.Run()
+	require.Error(t, runErr)
+
+	s, err := m.Snapshot()
+	require.NoError(t, err)
+	restored, err := opcode.Restore(s)
+	require.NoError(t, err)
+	require.True(t, restored.Halted())
+	require.EqualError(t, restored.Err(), runErr.Error())
+}
+
+func TestSnapshotUnsupportedIO(t *testing.T) {
+	cases := map[string]struct {
+		in  opcode.Input
+		out opcode.Output
+		err string
+	}{
+		"Channel input": {
+			in:  make(opcode.ChanInput),
+			err: "Can't snapshot input or output: input of type opcode.ChanInput",
+		},
+		"Channel output": {
+			out: make(opcode.ChanOutput),
+			err: "Can't snapshot input or output: output of type opcode.ChanOutput",
+		},
+		"Function output": {
+			in:  opcode.NewSliceInput(1),
+			out: opcode.OutputFunc(func(ctx context.Context, val int) error { return nil }),
+			err: "Can't snapshot input or output: output of type opcode.OutputFunc",
+		},
+	}
+
+	for name, data := range cases {
+		m := opcode.NewMachine([]int{3, 0, 4, 0, 99}, data.in, data.out)
+		_, err := m.Snapshot()
+		require.EqualErrorf(t, err, data.err, "Case %s", name)
+		require.Truef(t, errors.Is(err, opcode.ErrSnapshotIO), "Case %s", name)
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	_, err := opcode.LoadSnapshot(strings.NewReader(`{"version": 99, "memory": [99]}`))
+	require.EqualError(t, err, "Unsupported snapshot version 99")
+	require.True(t, errors.Is(err, opcode.ErrSnapshotVersion))
+
+	s, err := opcode.LoadSnapshot(strings.NewReader(`{"version": 1, "memory": [99], "sparse": {"0": 1}}`))
+	require.NoError(t, err)
+	_, err = opcode.Restore(s)
+	require.True(t, errors.Is(err, opcode.ErrInvalidSnapshot))
+}
@@ -0,0 +1,303 @@
+package opcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Topology describes a group of machines and how they are connected. Each node runs its own copy of a program, is
+// given some inputs to start with, such as a phase setting, and then reads the outputs of the nodes connected to it
+type Topology struct {
+	nodes  []*topologyNode
+	output int
+	// err is the first mistake made while building the topology, reported by Run
+	err error
+}
+
+// topologyNode is a single machine in a topology
+type topologyNode struct {
+	codes   []int
+	inputs  []int
+	targets []int
+	sources int
+}
+
+// NewTopology creates an empty topology
+func NewTopology() *Topology {
+	return &Topology{
+		output: -1,
+	}
+}
+
+// Pipeline creates a topology of machines running copies of a program, one for each list of inputs, with the outputs
+// of each machine going to the next. The result is the final output of the last machine
+func Pipeline(codes []int, inputs ...[]int) *Topology {
+	t := NewTopology()
+	for i := range inputs {
+		t.AddNode(codes, inputs[i]...)
+		if i > 0 {
+			t.Connect(i-1, i)
+		}
+	}
+	return t
+}
+
+// Ring creates a topology in the same way as Pipeline, but with the outputs of the last machine also going back to the
+// first, so they form a feedback loop
+func Ring(codes []int, inputs ...[]int) *Topology {
+	t := Pipeline(codes, inputs...)
+	if len(inputs) > 0 {
+		t.Connect(len(inputs)-1, 0)
+	}
+	return t
+}
+
+// AddNode adds a machine running a copy of the program to the topology, which is given `inputs` before anything sent to
+// it by other nodes. It returns the ID of the node, which counts up from 0. The last node added is the output of the
+// topology unless SetOutput is called
+func (t *Topology) AddNode(codes []int, inputs ...int) int {
+	t.nodes = append(t.nodes, &topologyNode{
+		codes:  codes,
+		inputs: append([]int(nil), inputs...),
+	})
+	return len(t.nodes) - 1
+}
+
+// Connect sends every output of node `from` to node `to`. A node connected to several others sends each of them every
+// output, and a node connected from several others reads their outputs in the order they arrive
+func (t *Topology) Connect(from, to int) *Topology {
+	if !t.valid(from) || !t.valid(to) {
+		t.fail(fmt.Errorf("Can't connect node %d to node %d, there are only %d nodes", from, to, len(t.nodes)))
+		return t
+	}
+	t.nodes[from].targets = append(t.nodes[from].targets, to)
+	t.nodes[to].sources++
+	return t
+}
+
+// SetOutput chooses the node whose final output is the result of the topology
+func (t *Topology) SetOutput(node int) *Topology {
+	if !t.valid(node) {
+		t.fail(fmt.Errorf("Can't use node %d as the output, there are only %d nodes", node, len(t.nodes)))
+		return t
+	}
+	t.output = node
+	return t
+}
+
+// Run runs every machine in the topology until they have all halted, and returns the final output of the output node
+func (t *Topology) Run() (int, error) {
+	return t.RunContext(context.Background())
+}
+
+// RunContext runs the topology in the same way as Run, but stops every machine when the context is cancelled. Each
+// machine runs on its own goroutine, and if one of them fails the rest are stopped. If every machine still running
+// ends up waiting for input with none on its way, they are stopped and a DeadlockError is returned
+func (t *Topology) RunContext(ctx context.Context) (int, error) {
+	output, err := t.check()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queues := make([]*Queue, len(t.nodes))
+	machines := make([]*Machine, len(t.nodes))
+	for i, node := range t.nodes {
+		queues[i] = NewQueue(append([]int(nil), node.inputs...)...)
+	}
+
+	// lock guards everything below, and is held whenever a value is put in a queue so that a machine is never counted
+	// as waiting once input is on its way to it
+	var (
+		lock     sync.Mutex
+		last     int
+		hasLast  bool
+		firstErr error
+		sources  = make([]int, len(t.nodes))
+		waiting  = make([]bool, len(t.nodes))
+		halted   = make([]bool, len(t.nodes))
+		wg       sync.WaitGroup
+	)
+	for i, node := range t.nodes {
+		sources[i] = node.sources
+	}
+
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	// checkDeadlock stops the machines if every one still running is waiting for input that will never come. A machine
+	// whose queue has been closed is waiting forever, and is stopped before it reads the end of the queue
+	checkDeadlock := func() {
+		deadlock := &DeadlockError{}
+		for i := range t.nodes {
+			if halted[i] {
+				continue
+			}
+			if !waiting[i] || queues[i].Len() > 0 {
+				return
+			}
+			deadlock.Waiting = append(deadlock.Waiting, newWaiting(i, machines[i], t.sources(i), func(source int) bool {
+				return halted[source]
+			}))
+		}
+		if len(deadlock.Waiting) > 0 {
+			fail(deadlock)
+		}
+	}
+
+	for i, node := range t.nodes {
+		i, node := i, node
+		in := InputFunc(func(ctx context.Context) (int, error) {
+			lock.Lock()
+			if queues[i].Len() == 0 {
+				waiting[i] = true
+				checkDeadlock()
+			}
+			lock.Unlock()
+
+			val, err := queues[i].Read(ctx)
+			if err == io.EOF {
+				// Nothing more will arrive, so the machine waits forever. It stays counted as waiting until it is
+				// stopped, either as part of a deadlock once the other machines have halted or are waiting too, or
+				// because another machine failed
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+
+			lock.Lock()
+			waiting[i] = false
+			lock.Unlock()
+			return val, err
+		})
+		out := OutputFunc(func(ctx context.Context, val int) error {
+			lock.Lock()
+			defer lock.Unlock()
+			for _, target := range node.targets {
+				if err := queues[target].Write(ctx, val); err != nil {
+					return err
+				}
+				waiting[target] = false
+			}
+			if i == output {
+				last, hasLast = val, true
+			}
+			return nil
+		})
+		machines[i] = NewMachine(append(node.codes[:0:0], node.codes...), in, out)
+	}
+
+	for i, node := range t.nodes {
+		i, node, m := i, node, machines[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.RunContext(ctx)
+
+			lock.Lock()
+			defer lock.Unlock()
+			halted[i] = true
+			if err != nil {
+				fail(fmt.Errorf("Node %d: %w", i, err))
+			}
+
+			// Nothing more will arrive at a node once everything connected to it has stopped
+			for _, target := range node.targets {
+				sources[target]--
+				if sources[target] == 0 {
+					queues[target].Close()
+				}
+			}
+			checkDeadlock()
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if !hasLast {
+		return 0, fmt.Errorf("Node %d produced no output", output)
+	}
+	return last, nil
+}
+
+// RunScheduled runs the topology in the same way as Run, but with the machines taking turns on a single goroutine using
+// a Scheduler, so that it runs exactly the same way every time
+func (t *Topology) RunScheduled() (int, error) {
+	output, err := t.check()
+	if err != nil {
+		return 0, err
+	}
+
+	s := NewScheduler()
+	for _, node := range t.nodes {
+		m := NewSyncMachine(append(node.codes[:0:0], node.codes...))
+		m.PushInput(node.inputs...)
+		if _, err := s.Add(m); err != nil {
+			return 0, err
+		}
+	}
+	for i, node := range t.nodes {
+		for _, target := range node.targets {
+			if err := s.Connect(i, target); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := s.Run(); err != nil {
+		return 0, err
+	}
+	outputs := s.Outputs(output)
+	if len(outputs) == 0 {
+		return 0, fmt.Errorf("Node %d produced no output", output)
+	}
+	return outputs[len(outputs)-1], nil
+}
+
+// check returns the output node of the topology, or the first mistake made building it
+func (t *Topology) check() (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if len(t.nodes) == 0 {
+		return 0, errors.New("Topology has no nodes")
+	}
+	if t.output < 0 {
+		return len(t.nodes) - 1, nil
+	}
+	return t.output, nil
+}
+
+// sources returns the nodes connected to the given node
+func (t *Topology) sources(node int) []int {
+	sources := []int{}
+	for i, n := range t.nodes {
+		for _, target := range n.targets {
+			if target == node {
+				sources = append(sources, i)
+			}
+		}
+	}
+	return sources
+}
+
+// valid returns true iff the node ID exists
+func (t *Topology) valid(node int) bool {
+	return node >= 0 && node < len(t.nodes)
+}
+
+// fail records a mistake made building the topology, unless there has already been one
+func (t *Topology) fail(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
@@ -0,0 +1,195 @@
+package opcode_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"aoc/opcode"
+)
+
+func TestTopology(t *testing.T) {
+	amplifier := []int{3, 15, 3, 16, 1002, 16, 10, 16, 1, 16, 15, 15, 4, 15, 99, 0, 0}
+	feedback := []int{3, 26, 1001, 26, -4, 26, 3, 27, 1002, 27, 2, 27, 1, 27, 26, 27, 4, 27, 1001, 28, -1, 28, 1005, 28, 6, 99, 0, 0, 5}
+	echo := opcode.MustAssemble(`
+		in [x]
+		out [x]
+		hlt
+		x: data 0
+	`)
+	increment := opcode.MustAssemble(`
+		in [x]
+		add [x], #1, [x]
+		out [x]
+		hlt
+		x: data 0
+	`)
+	tenTimes := opcode.MustAssemble(`
+		in [x]
+		mul [x], #10, [x]
+		out [x]
+		hlt
+		x: data 0
+	`)
+
+	// fanOut sends the output of one machine to two others
+	fanOut := func() *opcode.Topology {
+		topology := opcode.NewTopology()
+		src := topology.AddNode(echo, 5)
+		topology.Connect(src, topology.AddNode(increment))
+		topology.Connect(src, topology.AddNode(tenTimes))
+		return topology
+	}
+
+	cases := map[string]struct {
+		topology *opcode.Topology
+		output   int
+	}{
+		"Pipeline": {
+			topology: opcode.Pipeline(amplifier, []int{4, 0}, []int{3}, []int{2}, []int{1}, []int{0}),
+			output:   43210,
+		},
+		"Single node pipeline": {
+			topology: opcode.Pipeline(increment, []int{41}),
+			output:   42,
+		},
+		"Ring": {
+			topology: opcode.Ring(feedback, []int{9, 0}, []int{8}, []int{7}, []int{6}, []int{5}),
+			output:   139629729,
+		},
+		"Fan out to last node": {
+			topology: fanOut(),
+			output:   50,
+		},
+		"Fan out to chosen node": {
+			topology: fanOut().SetOutput(1),
+			output:   6,
+		},
+	}
+
+	for name, data := range cases {
+		output, err := data.topology.Run()
+		require.NoErrorf(t, err, "Case %s", name)
+		require.Equal(t, data.output, output, "Case %s", name)
+
+		// Running the machines on a single goroutine gives the same result
+		output, err = data.topology.RunScheduled()
+		require.NoErrorf(t, err, "Case %s scheduled", name)
+		require.Equal(t, data.output, output, "Case %s scheduled", name)
+	}
+}
+
+func TestTopologyErrors(t *testing.T) {
+	halt := []int{99}
+	add := opcode.MustAssemble(`
+		in [x]
+		in [y]
+		add [x], [y], [x]
+		out [x]
+		hlt
+		x: data 0
+		y: data 0
+	`)
+
+	cases := map[string]struct {
+		topology *opcode.Topology
+		err      string
+		is       error
+	}{
+		"No nodes": {
+			topology: opcode.NewTopology(),
+			err:      "Topology has no nodes",
+		},
+		"Bad connection": {
+			topology: opcode.Pipeline(halt, nil).Connect(0, 1),
+			err:      "Can't connect node 0 to node 1, there are only 1 nodes",
+		},
+		"Bad output": {
+			topology: opcode.Pipeline(halt, nil).SetOutput(-1),
+			err:      "Can't use node -1 as the output, there are only 1 nodes",
+		},
+		"No output": {
+			topology: opcode.Pipeline(halt, nil),
+			err:      "Node 0 produced no output",
+		},
+		"Input runs out": {
+			topology: opcode.Pipeline(add, []int{1, 2}, nil),
+			err:      "Every machine is waiting for input: machine 1 at IP 2 (in [12]) won't get any more input, machine 0 has halted",
+			is:       opcode.ErrDeadlock,
+		},
+	}
+
+	for name, data := range cases {
+		// Running the machines on goroutines or on a single goroutine fails in the same way
+		for _, run := range []func() (int, error){data.topology.Run, data.topology.RunScheduled} {
+			_, err := run()
+			require.Errorf(t, err, "Case %s", name)
+			require.Contains(t, err.Error(), data.err, "Case %s", name)
+			if data.is != nil {
+				require.Truef(t, errors.Is(err, data.is), "Case %s", name)
+			}
+		}
+	}
+}
+
+func TestTopologyDeadlock(t *testing.T) {
+	feedback := []int{3, 26, 1001, 26, -4, 26, 3, 27, 1002, 27, 2, 27, 1, 27, 26, 27, 4, 27, 1001, 28, -1, 28, 1005, 28, 6, 99, 0, 0, 5}
+	// Forgetting to give the first amplifier 0 leaves every amplifier waiting for the one before it
+	topology := opcode.Ring(feedback, []int{9}, []int{8}, []int{7}, []int{6}, []int{5})
+
+	expected := []opcode.Waiting{
+		{ID: 0, IP: 6, Instruction: "in [27]", Sources: []int{4}},
+		{ID: 1, IP: 6, Instruction: "in [27]", Sources: []int{0}},
+		{ID: 2, IP: 6, Instruction: "in [27]", Sources: []int{1}},
+		{ID: 3, IP: 6, Instruction: "in [27]", Sources: []int{2}},
+		{ID: 4, IP: 6, Instruction: "in [27]", Sources: []int{3}},
+	}
+
+	cases := map[string]func() (int, error){
+		"Goroutines": topology.Run,
+		"Scheduled":  topology.RunScheduled,
+	}
+
+	for name, run := range cases {
+		_, err := run()
+		require.Truef(t, errors.Is(err, opcode.ErrDeadlock), "Case %s", name)
+		var deadlock *opcode.DeadlockError
+		require.Truef(t, errors.As(err, &deadlock), "Case %s", name)
+		require.Equal(t, expected, deadlock.Waiting, "Case %s", name)
+		require.Contains(t, err.Error(), "machine 0 at IP 6 (in [27]) is waiting for machine 4", "Case %s", name)
+	}
+}
+
+func TestTopologyDeadlockWhileRunning(t *testing.T) {
+	// Node 1 needs two inputs but node 0 only gives it one, while node 2 carries on with something else for a while
+	topology := opcode.NewTopology()
+	topology.AddNode([]int{104, 1, 99})
+	topology.AddNode(opcode.MustAssemble(`
+		in [x]
+		in [y]
+		add [x], [y], [x]
+		out [x]
+		hlt
+		x: data 0
+		y: data 0
+	`))
+	topology.AddNode(countdown(1000000))
+	topology.Connect(0, 1).SetOutput(1)
+
+	expected := []opcode.Waiting{
+		{ID: 1, IP: 2, Instruction: "in [12]", Halted: []int{0}},
+	}
+
+	cases := map[string]func() (int, error){
+		"Goroutines": topology.Run,
+		"Scheduled":  topology.RunScheduled,
+	}
+
+	for name, run := range cases {
+		_, err := run()
+		var deadlock *opcode.DeadlockError
+		require.Truef(t, errors.As(err, &deadlock), "Case %s: %v", name, err)
+		require.Equal(t, expected, deadlock.Waiting, "Case %s", name)
+	}
+}
@@ -0,0 +1,51 @@
+package opcode
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// NewTracer returns hooks that write a line to `w` for every instruction a machine executes, showing the decoded
+// operands and the values they resolved to. For example, adding 1 to the value 4 at address 20 and storing the result
+// at address 21 is traced as
+//
+//	0002  rb=0     add [20]=4, #1, [21]<-5
+//
+// The relative base shown is the one the operands were resolved with, before the instruction ran. The same hooks can be
+// added to several machines at once, and lines from different machines won't be interleaved
+func NewTracer(w io.Writer) Hooks {
+	lock := sync.Mutex{}
+	return Hooks{
+		AfterInstruction: func(m *Machine, ip int, instruction Instruction, operands []Operand) {
+			def, _ := m.instructions.Lookup(instruction)
+			line := fmt.Sprintf("%04d  rb=%-5d %s\n", ip, m.hookRelativeBase, formatTrace(def, operands))
+
+			lock.Lock()
+			defer lock.Unlock()
+			io.WriteString(w, line)
+		},
+	}
+}
+
+// formatTrace formats an executed instruction with its resolved operands
+func formatTrace(def *InstructionDef, operands []Operand) string {
+	formatted := make([]string, len(operands))
+	for i, op := range operands {
+		s := op.String()
+		if op.Mode == ModeRelative && op.Resolved {
+			s += fmt.Sprintf("(%d)", op.Addr)
+		}
+
+		switch {
+		case !op.Resolved || op.Mode == ModeImmediate:
+		case def.writes(i):
+			s += fmt.Sprintf("<-%d", op.Value)
+		default:
+			s += fmt.Sprintf("=%d", op.Value)
+		}
+		formatted[i] = s
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s", def.Mnemonic, strings.Join(formatted, ", ")))
+}